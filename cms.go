@@ -5,7 +5,11 @@ package approx
 
 import (
 	"errors"
+	"fmt"
 	"math"
+	"math/bits"
+	"sync/atomic"
+	"unsafe"
 
 	"github.com/zeebo/xxh3"
 )
@@ -13,14 +17,26 @@ import (
 const (
 	defaultEpsilon    = 0.001
 	defaultConfidence = 0.99
-	stripe            = 4
+)
+
+// Limits of the CountMin sketch, which callers can check before constructing a sketch
+const (
+	Stripe   = 4                                                // number of counters packed in a Count16x4
+	MaxDepth = 128                                              // maximum number of rows (hash functions)
+	MaxWidth = min(math.MaxUint32, math.MaxInt) &^ (Stripe - 1) // maximum number of columns, a multiple of Stripe
 )
 
 // CountMin is a sketch data structure for estimating the frequency of items in a stream
 type CountMin struct {
-	depth  int           // number of hash functions
-	width  int           // number of counters per hash function
-	counts [][]Count16x4 // 2D array of counters
+	depth   int                          // number of hash functions
+	width   int                          // number of counters per hash function
+	counts  [][]Count16x4                // 2D array of counters
+	total   atomic.Uint64                // total number of observations
+	cleared [MaxDepth / 64]atomic.Uint64 // bitmask of rows excluded by ClearRow
+	seq     atomic.Uint64                // sequence of updates, used in deterministic mode
+	pseudo  bool                         // whether the rolls are derived from the sequence
+	skip    bool                         // whether updates of empty items are ignored
+	mixed   bool                         // whether each row re-mixes the hash independently
 }
 
 // NewCountMin creates a new CountMin sketch with default epsilon and confidence
@@ -30,7 +46,9 @@ func NewCountMin() (*CountMin, error) {
 
 // NewCountMinWithEpsilon creates a new CountMin sketch with the given epsilon and delta. The epsilon
 // parameter controls the accuracy of the estimates, and the confidence parameter controls the
-// probability that the estimates are within the specified error bounds.
+// probability that the estimates are within the specified error bounds. The depth derived from
+// the confidence is rounded up to an even number, as required by NewCountMinWithSize, so the
+// realized confidence may be higher than requested, see Confidence.
 func NewCountMinWithEstimates(epsilon, confidence float64) (*CountMin, error) {
 	switch {
 	case epsilon <= 0 || epsilon >= 1:
@@ -42,25 +60,28 @@ func NewCountMinWithEstimates(epsilon, confidence float64) (*CountMin, error) {
 	delta := 1 - confidence
 	width := uint(math.Ceil(math.E / epsilon))
 	depth := uint(math.Ceil(math.Log(1 / delta)))
+	depth += depth % 2 // round up to an even depth
 	return NewCountMinWithSize(depth, width)
 }
 
-// NewCountMinWithSize creates a new CountMin sketch with the given depth and width
+// NewCountMinWithSize creates a new CountMin sketch with the given depth and width. The
+// width is rounded up to the next multiple of 4, see Width() for the realized width.
 func NewCountMinWithSize(depth, width uint) (*CountMin, error) {
 	switch {
 	case depth%2 != 0:
 		return nil, errors.New("sketch: depth should be divisible by 2")
-	case depth > 128:
-		return nil, errors.New("sketch: depth should be less than 128")
-	case width%4 != 0:
-		return nil, errors.New("sketch: width should be a divisible by 4")
-	case width > math.MaxInt32:
-		return nil, errors.New("sketch: width should be less than MaxInt32")
+	case depth > MaxDepth:
+		return nil, errors.New("sketch: depth should be at most MaxDepth (128)")
+	case width > MaxWidth:
+		return nil, errors.New("sketch: width should be at most MaxWidth")
 	}
 
+	// Round up the width to a multiple of Stripe, this can't overflow since MaxWidth is aligned
+	width = (width + Stripe - 1) / Stripe * Stripe
+
 	mx := make([][]Count16x4, depth)
 	for i := range mx {
-		mx[i] = make([]Count16x4, width/stripe)
+		mx[i] = make([]Count16x4, width/Stripe)
 	}
 
 	return &CountMin{
@@ -70,32 +91,216 @@ func NewCountMinWithSize(depth, width uint) (*CountMin, error) {
 	}, nil
 }
 
-// Update increments the counter for the given item
+// NewCountMinDeterministic creates a new CountMin sketch with the given depth and width,
+// whose increment decisions are driven by a pseudo-roll derived from the item hash and
+// the sequence number of the update, instead of a random roll. Replaying the same stream
+// in the same order then yields an identical sketch, which is useful for audited batch
+// jobs. The pseudo-rolls are well mixed, so the accuracy is statistically the same as in
+// the random mode, but the reproducibility only holds for a single writer since the
+// interleaving of concurrent updates is not deterministic. The sequence is not part of
+// the binary encoding and restarts after Reset.
+func NewCountMinDeterministic(depth, width uint) (*CountMin, error) {
+	c, err := NewCountMinWithSize(depth, width)
+	if err != nil {
+		return nil, err
+	}
+
+	c.pseudo = true
+	return c, nil
+}
+
+// NewCountMinSkipEmpty creates a new CountMin sketch with the given depth and width, which
+// ignores updates of empty items. By default, an empty or nil item is hashed like any
+// other item and counted, so that all of the items of a stream are accounted for. With
+// this sketch, the Update and Add methods of empty items are no-ops which return false
+// or a zero estimate, and do not count towards the total. Since hashes can't be empty,
+// the *Hash methods are unaffected.
+func NewCountMinSkipEmpty(depth, width uint) (*CountMin, error) {
+	c, err := NewCountMinWithSize(depth, width)
+	if err != nil {
+		return nil, err
+	}
+
+	c.skip = true
+	return c, nil
+}
+
+// NewCountMinIndependent creates a new CountMin sketch with the given depth and width,
+// where each row re-mixes the hash of the item with its own seed instead of the default
+// double hashing lo + i*hi. With double hashing, items whose hashes share the same lower
+// half and have a zero upper half collide in every row, which an adversarial or unlucky
+// set of items can exploit to inflate the error. Independent rows cost a few extra
+// multiplications per row. Such a sketch can only be combined with another independent
+// sketch.
+func NewCountMinIndependent(depth, width uint) (*CountMin, error) {
+	c, err := NewCountMinWithSize(depth, width)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mixed = true
+	return c, nil
+}
+
+// NewCountMinWithMemory creates a new CountMin sketch whose SizeBytes fits into the given
+// memory budget. The geometry favours the width for accuracy, using a depth of 4 unless
+// the budget is too small to keep a reasonable width. See Epsilon and Confidence for
+// the realized error bounds.
+func NewCountMinWithMemory(bytes int) (*CountMin, error) {
+	depth := 4
+	if bytes < 32*1024 {
+		depth = 2
+	}
+
+	// Subtract the overhead of the structure and its rows, and divide the remaining
+	// budget into rows of packed counters.
+	overhead := int(unsafe.Sizeof(CountMin{})) + depth*int(unsafe.Sizeof([]Count16x4(nil)))
+	words := (bytes - overhead) / depth / int(unsafe.Sizeof(Count16x4{}))
+	if words < 1 {
+		return nil, errors.New("sketch: memory budget is too small")
+	}
+
+	return NewCountMinWithSize(uint(depth), uint(min(words*Stripe, MaxWidth)))
+}
+
+// NewCountMinFromCells creates a new CountMin sketch which adopts the given packed
+// counters without copying them, where each of the depth rows holds width/4 counters.
+// The width must be a multiple of 4. Since the number of observations is not known,
+// the total of the sketch starts at zero.
+func NewCountMinFromCells(depth, width uint, cells [][]Count16x4) (*CountMin, error) {
+	switch {
+	case depth%2 != 0:
+		return nil, errors.New("sketch: depth should be divisible by 2")
+	case depth > MaxDepth:
+		return nil, errors.New("sketch: depth should be at most MaxDepth (128)")
+	case width > MaxWidth:
+		return nil, errors.New("sketch: width should be at most MaxWidth")
+	case width%Stripe != 0:
+		return nil, errors.New("sketch: width should be a multiple of Stripe (4)")
+	case uint(len(cells)) != depth:
+		return nil, errors.New("sketch: number of rows should match the depth")
+	}
+
+	for _, row := range cells {
+		if uint(len(row)) != width/Stripe {
+			return nil, errors.New("sketch: number of cells in a row should match the width")
+		}
+	}
+
+	return &CountMin{
+		depth:  int(depth),
+		width:  int(width),
+		counts: cells,
+	}, nil
+}
+
+// reduce maps the lower 32 bits of the hash uniformly onto [0, n) using the multiply-shift
+// reduction, which avoids both the modulo and its bias. Since n is at most MaxUint32, the
+// product can't overflow and the result always fits into an int on 64-bit platforms. See https://lemire.me/blog/2016/06/27/a-fast-alternative-to-the-modulo-reduction/
+func reduce(hash, n uint64) int {
+	return int(((hash & 0xFFFFFFFF) * n) >> 32)
+}
+
+// index returns the hash which selects the column of the item in the given row. The rows
+// use double hashing, combining the lower and upper halves of the hash, unless they are
+// mixed independently, see NewCountMinIndependent.
+func index(hash uint64, row int, mixed bool) uint64 {
+	if mixed {
+		return mix64(hash + uint64(row+1)*0x9E3779B97F4A7C15)
+	}
+	return (hash & ((1 << 32) - 1)) + uint64(row)*(hash>>32)
+}
+
+// mix64 is the finalizer of splitmix64, which spreads every bit of the input over the
+// whole output.
+func mix64(x uint64) uint64 {
+	x = (x ^ (x >> 30)) * 0xBF58476D1CE4E5B9
+	x = (x ^ (x >> 27)) * 0x94D049BB133111EB
+	return x ^ (x >> 31)
+}
+
+// cell resolves the flat column index of a row into the packed counter word holding it
+// and the lane of the counter within that word, since 4 counters are packed per word.
+func cell(row []Count16x4, col int) (at *Count16x4, lane int) {
+	return &row[col/Stripe], col % Stripe
+}
+
+// Depth returns the number of hash functions (rows) of the sketch
+func (c *CountMin) Depth() int {
+	return c.depth
+}
+
+// Width returns the number of counters per hash function (columns) of the sketch
+func (c *CountMin) Width() int {
+	return c.width
+}
+
+// Epsilon returns the error factor of the sketch, where the estimates overshoot the true
+// count by at most epsilon times the total count with the probability of Confidence.
+func (c *CountMin) Epsilon() float64 {
+	return math.E / float64(c.width)
+}
+
+// Confidence returns the probability that the estimates are within the error bounds
+func (c *CountMin) Confidence() float64 {
+	return 1 - math.Exp(-float64(c.depth))
+}
+
+// roll returns the roll in [0, 1) used to decide whether the counters of the given
+// item are incremented, which is random unless the sketch is deterministic.
+func (c *CountMin) roll(hash uint64) float32 {
+	if !c.pseudo {
+		return roll32()
+	}
+
+	// Mix the hash with the sequence using the finalizer of splitmix64
+	x := mix64(hash ^ (c.seq.Add(1) * 0x9E3779B97F4A7C15))
+	return float32(x>>40) / (1 << 24)
+}
+
+// Update increments the counter for the given item. Empty items are counted like any other
+// item, unless the sketch was created with NewCountMinSkipEmpty.
 func (c *CountMin) Update(item []byte) bool {
+	if len(item) == 0 && c.skip {
+		return false
+	}
+
 	return c.UpdateHash(xxh3.Hash(item))
 }
 
 // UpdateString increments the counter for the given item
 func (c *CountMin) UpdateString(item string) bool {
+	if len(item) == 0 && c.skip {
+		return false
+	}
+
 	return c.UpdateHash(xxh3.HashString(item))
 }
 
 // UpdateHash increments the counter for the given item
-func (c *CountMin) UpdateHash(hash uint64) (updated bool) {
-	lo := hash & ((1 << 32) - 1) // Lower 32 bits
-	hi := hash >> 32             // Upper 32 bits
+func (c *CountMin) UpdateHash(hash uint64) bool {
+	return c.updateHashWithRoll(hash, c.roll(hash)) // Keep same random value for all counters
+}
+
+// updateHashWithRoll increments the counter for the given item, using the provided roll
+// in [0, 1) to decide whether each counter is incremented. This makes the update fully
+// deterministic for a given hash and roll, which is useful for testing.
+func (c *CountMin) updateHashWithRoll(hash uint64, roll float32) (updated bool) {
+	c.total.Add(1)
 
 	// Find the minimum counter value and increment the counter at the given index
-	w := c.width
-	r := roll32() // Keep same random value for all counters
+	t := lookup16()
+	w, mixed := uint64(c.width), c.mixed
 	for i := 0; i < c.depth; i++ {
-		hx := lo + uint64(i)*hi
+		if c.isCleared(i) {
+			continue
+		}
+
+		hx := index(hash, i, mixed)
 
-		// Calculate the index of the counter to increment (4 are packed),
-		// hence we use stripe to find the index of the counter
-		idx := int(hx) % w
-		at := &c.counts[i][idx/stripe]
-		if at.incrementAt(idx%stripe, r) {
+		// Calculate the index of the counter to increment (4 are packed)
+		at, lane := cell(c.counts[i], reduce(hx, w))
+		if at.incrementAt(t, lane, roll) {
 			updated = true
 		}
 	}
@@ -103,6 +308,262 @@ func (c *CountMin) UpdateHash(hash uint64) (updated bool) {
 	return updated
 }
 
+// UpdateUint64s increments the counters of a batch of fixed-size keys, where each key is
+// hashed as its 8-byte little-endian encoding. This is equivalent to calling Update for
+// each of the encoded keys, but processes the batch in blocks: the keys of a block are
+// hashed and their rolls drawn first in tight loops, then the counters are updated row
+// by row, which keeps each row hot in the cache.
+func (c *CountMin) UpdateUint64s(keys []uint64) {
+	const block = 64
+	var hashes [block]uint64
+	var rolls [block]float32
+
+	c.total.Add(uint64(len(keys)))
+	t := lookup16()
+	w, mixed := uint64(c.width), c.mixed
+	for len(keys) > 0 {
+		batch := keys[:min(len(keys), block)]
+		keys = keys[len(batch):]
+
+		// Hash all of the keys of the block, along with their rolls
+		for j, key := range batch {
+			hashes[j] = hashUint64(key)
+		}
+		switch {
+		case c.pseudo:
+			for j := range batch {
+				rolls[j] = c.roll(hashes[j])
+			}
+		default: // Draw two 24-bit rolls from each random value
+			for j := 0; j < len(batch); j += 2 {
+				r := runtime_rand()
+				rolls[j] = float32(r&(1<<24-1)) / (1 << 24)
+				rolls[j+1] = float32(r>>40) / (1 << 24)
+			}
+		}
+
+		// Increment the counters, one row at a time
+		for i := 0; i < c.depth; i++ {
+			if c.isCleared(i) {
+				continue
+			}
+
+			row := c.counts[i]
+			for j, hash := range hashes[:len(batch)] {
+				hx := index(hash, i, mixed)
+				at, lane := cell(row, reduce(hx, w))
+				at.incrementAt(t, lane, rolls[j])
+			}
+		}
+	}
+}
+
+// CountUint64 returns the estimated frequency of the given fixed-size key, hashed as its
+// 8-byte little-endian encoding, see UpdateUint64s.
+func (c *CountMin) CountUint64(key uint64) uint {
+	return c.CountHash(hashUint64(key))
+}
+
+// hashUint64 returns the xxh3 hash of the 8-byte little-endian encoding of the key. This
+// is the same as xxh3.Hash of the encoded key, without the encoding and the dispatch on
+// the length of the input.
+func hashUint64(key uint64) uint64 {
+	h := bits.RotateLeft64(key, 32) ^ (0x1cad21f72c81017c ^ 0xdb979083e96dd4de)
+	h ^= bits.RotateLeft64(h, 49) ^ bits.RotateLeft64(h, 24)
+	h *= 0x9fb21c651e98df25
+	h ^= (h >> 35) + 8
+	h *= 0x9fb21c651e98df25
+	h ^= h >> 28
+	return h
+}
+
+// UpdateAndCount increments the counter for the given item and returns its new estimate
+func (c *CountMin) UpdateAndCount(item []byte) uint {
+	if len(item) == 0 && c.skip {
+		return 0
+	}
+
+	return c.UpdateAndCountHash(xxh3.Hash(item))
+}
+
+// UpdateAndCountString increments the counter for the given item and returns its new estimate
+func (c *CountMin) UpdateAndCountString(item string) uint {
+	if len(item) == 0 && c.skip {
+		return 0
+	}
+
+	return c.UpdateAndCountHash(xxh3.HashString(item))
+}
+
+// UpdateAndCountHash increments the counter for the given item and returns its new estimate.
+// This is equivalent to UpdateHash followed by CountHash, but only requires a single pass.
+func (c *CountMin) UpdateAndCountHash(hash uint64) uint {
+	count, _ := c.updateAndCount(hash, c.roll(hash))
+	return count
+}
+
+// updateAndCount increments the counter for the given item, returning its new estimate
+// and whether any of the counters was updated.
+func (c *CountMin) updateAndCount(hash uint64, roll float32) (count uint, updated bool) {
+	c.total.Add(1)
+
+	x := ^uint(0)
+	t := lookup16()
+	w, mixed := uint64(c.width), c.mixed
+	for i := 0; i < c.depth; i++ {
+		if c.isCleared(i) {
+			continue
+		}
+
+		hx := index(hash, i, mixed)
+		at, lane := cell(c.counts[i], reduce(hx, w))
+		if at.incrementAt(t, lane, roll) {
+			updated = true
+		}
+
+		x = min(x, t.n[at.state(lane)])
+	}
+
+	if x == ^uint(0) {
+		return 0, updated // every row was cleared
+	}
+	return x, updated
+}
+
+// Add increments the counter for the given item by n observations
+func (c *CountMin) Add(item []byte, n uint) bool {
+	if len(item) == 0 && c.skip {
+		return false
+	}
+
+	return c.AddHash(xxh3.Hash(item), n)
+}
+
+// AddString increments the counter for the given item by n observations
+func (c *CountMin) AddString(item string, n uint) bool {
+	if len(item) == 0 && c.skip {
+		return false
+	}
+
+	return c.AddHash(xxh3.HashString(item), n)
+}
+
+// AddHash increments the counter for the given item by n observations. This is
+// equivalent to calling UpdateHash n times, but only requires a single pass.
+func (c *CountMin) AddHash(hash uint64, n uint) (updated bool) {
+	if n == 0 {
+		return false
+	}
+
+	_, updated = c.addAndCount(hash, n, c.roll(hash))
+	return updated
+}
+
+// AddAndCount increments the counter for the given item by n observations and returns
+// its new estimate.
+func (c *CountMin) AddAndCount(item []byte, n uint) uint {
+	if len(item) == 0 && c.skip {
+		return 0
+	}
+
+	return c.AddAndCountHash(xxh3.Hash(item), n)
+}
+
+// AddAndCountString increments the counter for the given item by n observations and
+// returns its new estimate.
+func (c *CountMin) AddAndCountString(item string, n uint) uint {
+	if len(item) == 0 && c.skip {
+		return 0
+	}
+
+	return c.AddAndCountHash(xxh3.HashString(item), n)
+}
+
+// AddAndCountHash increments the counter for the given item by n observations and returns
+// its new estimate. This is equivalent to AddHash followed by CountHash, but only requires
+// a single pass.
+func (c *CountMin) AddAndCountHash(hash uint64, n uint) uint {
+	count, _ := c.addAndCount(hash, n, c.roll(hash))
+	return count
+}
+
+// addAndCount increments the counter for the given item by n observations, returning
+// its new estimate and whether any of the counters was updated.
+func (c *CountMin) addAndCount(hash uint64, n uint, roll float32) (count uint, updated bool) {
+	c.total.Add(uint64(n))
+
+	x := ^uint(0)
+	t := lookup16()
+	w, mixed := uint64(c.width), c.mixed
+	for i := 0; i < c.depth; i++ {
+		if c.isCleared(i) {
+			continue
+		}
+
+		hx := index(hash, i, mixed)
+		at, lane := cell(c.counts[i], reduce(hx, w))
+		if n > 0 && at.incrementAtN(lane, n, roll) {
+			updated = true
+		}
+
+		x = min(x, t.n[at.state(lane)])
+	}
+
+	if x == ^uint(0) {
+		return 0, updated // every row was cleared
+	}
+	return x, updated
+}
+
+// SubtractCount decreases the counter for the given item by n observations
+func (c *CountMin) SubtractCount(item []byte, n uint) {
+	c.SubtractCountHash(xxh3.Hash(item), n)
+}
+
+// SubtractCountString decreases the counter for the given item by n observations
+func (c *CountMin) SubtractCountString(item string, n uint) {
+	c.SubtractCountHash(xxh3.HashString(item), n)
+}
+
+// SubtractCountHash decreases the counter for the given item by delta observations, which
+// allows rolling back a known over-count. Each cell is re-encoded to the nearest state
+// of its estimate minus delta, clamped at zero. This is best-effort: since cells are shared
+// with other items, the correction also applies to colliding items and the estimate of
+// the item itself can be under-corrected.
+func (c *CountMin) SubtractCountHash(hash uint64, delta uint) {
+	if delta == 0 {
+		return
+	}
+
+	for total := c.total.Load(); !c.total.CompareAndSwap(total, total-min(total, uint64(delta))); {
+		total = c.total.Load()
+	}
+
+	w, mixed := uint64(c.width), c.mixed
+	for i := 0; i < c.depth; i++ {
+		if c.isCleared(i) {
+			continue
+		}
+
+		hx := index(hash, i, mixed)
+		at, lane := cell(c.counts[i], reduce(hx, w))
+		at.transform(func(l int, state uint16) uint16 {
+			if l != lane {
+				return state
+			}
+			return encode16(n(float64(state), scale16) - float64(delta))
+		})
+	}
+}
+
+// Fill adds the given counts to the sketch, where each key of the map was observed
+// the number of times specified by its value. Each key is added as with AddString.
+func (c *CountMin) Fill(counts map[string]uint) {
+	for item, n := range counts {
+		c.AddString(item, n)
+	}
+}
+
 // Count returns the estimated frequency of the given item
 func (c *CountMin) Count(item []byte) uint {
 	return c.CountHash(xxh3.Hash(item))
@@ -115,18 +576,426 @@ func (c *CountMin) CountString(item string) uint {
 
 // CountHash returns the estimated frequency of the given item
 func (c *CountMin) CountHash(hash uint64) uint {
-	lo := hash & ((1 << 32) - 1) // Lower 32 bits
-	hi := hash >> 32             // Upper 32 bits
+	estimate, _ := c.CountWithRowHash(hash)
+	return estimate
+}
+
+// CountWithRow returns the estimated frequency of the given item, along with the row
+// which produced it, see CountWithRowHash.
+func (c *CountMin) CountWithRow(item []byte) (estimate uint, row int) {
+	return c.CountWithRowHash(xxh3.Hash(item))
+}
 
-	x := ^uint32(0)
-	w := c.width
+// CountWithRowHash returns the estimated frequency of the given item, along with the
+// row which produced the minimum. If several rows tie, the first one is returned and
+// if every row was cleared, the row is -1. This helps diagnosing whether some rows
+// consistently dominate the estimates.
+func (c *CountMin) CountWithRowHash(hash uint64) (estimate uint, row int) {
+	x, row := ^uint32(0), -1
+	t := lookup16()
+	w, mixed := uint64(c.width), c.mixed
 	for i := 0; i < c.depth && x > 0; i++ {
-		hx := lo + uint64(i)*hi
-		idx := int(hx) % w
-		at := &c.counts[i][idx/stripe]
-		x = min(x, uint32(at.EstimateAt(idx%stripe)))
+		if c.isCleared(i) {
+			continue
+		}
+
+		hx := index(hash, i, mixed)
+		at, lane := cell(c.counts[i], reduce(hx, w))
+		if v := uint32(t.n[at.state(lane)]); v < x {
+			x, row = v, i
+		}
+	}
+
+	if row < 0 {
+		return 0, -1 // every row was cleared
+	}
+	return uint(x), row
+}
+
+// ClearRow zeros the counters of the given row and excludes it from subsequent updates
+// and estimates, which are then based on the remaining rows. This is an escape hatch
+// for a row degraded by a hot collision, and rows out of range are ignored. The row
+// is included again after Reset.
+func (c *CountMin) ClearRow(i int) {
+	if i < 0 || i >= c.depth {
+		return
+	}
+
+	for mask := &c.cleared[i/64]; ; {
+		if v := mask.Load(); mask.CompareAndSwap(v, v|1<<(i%64)) {
+			break
+		}
+	}
+
+	for j := range c.counts[i] {
+		c.counts[i][j].Reset()
+	}
+}
+
+// isCleared returns whether the row was excluded by ClearRow
+func (c *CountMin) isCleared(i int) bool {
+	return c.cleared[i/64].Load()&(1<<(i%64)) != 0
+}
+
+// CountHashBatch returns the estimated frequencies of the given items, in the same order
+func (c *CountMin) CountHashBatch(hashes []uint64) []uint {
+	out := make([]uint, len(hashes))
+	for i, hash := range hashes {
+		out[i] = c.CountHash(hash)
+	}
+	return out
+}
+
+// CountAll returns the estimated frequencies of the given items, in the same order
+func (c *CountMin) CountAll(items [][]byte) []uint {
+	out := make([]uint, len(items))
+	for i, item := range items {
+		out[i] = c.CountHash(xxh3.Hash(item))
 	}
-	return uint(x)
+	return out
+}
+
+// CountAllString returns the estimated frequencies of the given items, in the same order
+func (c *CountMin) CountAllString(items []string) []uint {
+	out := make([]uint, len(items))
+	for i, item := range items {
+		out[i] = c.CountHash(xxh3.HashString(item))
+	}
+	return out
+}
+
+// Total returns the total number of observations added to the sketch
+func (c *CountMin) Total() uint {
+	return uint(c.total.Load())
+}
+
+// Frequency returns the estimated relative frequency of the given item
+func (c *CountMin) Frequency(item []byte) float64 {
+	return c.FrequencyHash(xxh3.Hash(item))
+}
+
+// FrequencyString returns the estimated relative frequency of the given item
+func (c *CountMin) FrequencyString(item string) float64 {
+	return c.FrequencyHash(xxh3.HashString(item))
+}
+
+// FrequencyHash returns the estimated relative frequency of the given item, between
+// 0 and 1. If nothing was observed yet, the frequency is zero.
+func (c *CountMin) FrequencyHash(hash uint64) float64 {
+	total := c.total.Load()
+	if total == 0 {
+		return 0
+	}
+
+	return min(float64(c.CountHash(hash))/float64(total), 1)
+}
+
+// ForEachCell calls the function for every counter of the sketch with its estimate.
+func (c *CountMin) ForEachCell(fn func(row, col int, estimate uint)) {
+	for d, row := range c.counts {
+		for j := range row {
+			for i, v := range row[j].Estimate() {
+				fn(d, j*Stripe+i, v)
+			}
+		}
+	}
+}
+
+// Utilization returns the fraction of counters, between 0 and 1, whose estimate is above
+// 90% of the maximum count a counter can represent. A growing utilization indicates that
+// the sketch is close to saturation and needs to be resized or reset.
+func (c *CountMin) Utilization() float64 {
+	highWater := uint(MaxCount16 * 9 / 10)
+
+	var saturated int
+	c.ForEachCell(func(_, _ int, estimate uint) {
+		if estimate > highWater {
+			saturated++
+		}
+	})
+	return float64(saturated) / (float64(c.depth) * float64(c.width))
+}
+
+// SizeBytes returns the approximate memory footprint of the sketch in bytes, which
+// includes the packed counters, the row slice headers and the structure itself.
+func (c *CountMin) SizeBytes() int {
+	rows := c.depth * int(unsafe.Sizeof([]Count16x4(nil)))
+	cells := c.depth * (c.width / Stripe) * int(unsafe.Sizeof(Count16x4{}))
+	return int(unsafe.Sizeof(*c)) + rows + cells
+}
+
+// ResetIfSaturated resets the sketch if its utilization is above the given threshold,
+// between 0 and 1, and returns whether it was reset. Calling it periodically bounds the
+// error of a long-running sketch without any external bookkeeping.
+func (c *CountMin) ResetIfSaturated(threshold float64) bool {
+	if c.Utilization() <= threshold {
+		return false
+	}
+
+	c.Reset()
+	return true
+}
+
+// RowLoad returns, for every row, the fraction of counters with a non-zero estimate. A
+// row much denser than the others hints at a bad hash or a skewed stream, while rows
+// that are all dense indicate that the sketch needs to be wider.
+func (c *CountMin) RowLoad() []float64 {
+	load := make([]float64, c.depth)
+	c.ForEachCell(func(row, _ int, estimate uint) {
+		if estimate > 0 {
+			load[row]++
+		}
+	})
+
+	for i := range load {
+		load[i] /= float64(c.width)
+	}
+	return load
+}
+
+// Scale multiplies the estimate of every counter by the given factor, re-encoding each
+// to the nearest counter state. A factor below 1 shrinks the counts while a factor above
+// 1 grows them, and negative factors are treated as zero.
+func (c *CountMin) Scale(factor float64) {
+	scale := func(_ int, state uint16) uint16 {
+		if state == 0 {
+			return 0
+		}
+		return encode16(n(float64(state), scale16) * factor)
+	}
+
+	for d, row := range c.counts {
+		for j := range row {
+			c.counts[d][j].transform(scale)
+		}
+	}
+
+	c.total.Store(uint64(math.Round(float64(c.total.Load()) * max(factor, 0))))
+}
+
+// Subtract subtracts the counts of the other sketch from this one, which allows tracking
+// removals in a companion sketch. Each counter is clamped at zero, so estimates never go
+// negative. Note that since both sketches are approximate, the error of the difference
+// is larger than the error of either sketch on its own.
+func (c *CountMin) Subtract(other *CountMin) error {
+	if err := c.compatible(other); err != nil {
+		return err
+	}
+
+	for d, row := range c.counts {
+		for j := range row {
+			sub := other.counts[d][j].v.Load()
+			c.counts[d][j].transform(func(lane int, state uint16) uint16 {
+				delta := n(float64(uint16(sub>>(lane*16))), scale16)
+				return encode16(n(float64(state), scale16) - delta)
+			})
+		}
+	}
+
+	total, sub := c.total.Load(), other.total.Load()
+	c.total.Store(total - min(total, sub))
+	return nil
+}
+
+// Intersect returns a new sketch where each counter is the minimum of the corresponding
+// counters of both sketches, which estimates the frequency of items present in both
+// streams. Since the estimate is monotonic in the counter state, the minimum state is
+// also the minimum estimate. The total of the result is the smaller of both totals.
+func (c *CountMin) Intersect(other *CountMin) (*CountMin, error) {
+	if err := c.compatible(other); err != nil {
+		return nil, err
+	}
+
+	out := c.empty(other)
+	for d, row := range out.counts {
+		for j := range row {
+			a, b := c.counts[d][j].v.Load(), other.counts[d][j].v.Load()
+			row[j].transform(func(lane int, _ uint16) uint16 {
+				return min(uint16(a>>(lane*16)), uint16(b>>(lane*16)))
+			})
+		}
+	}
+
+	out.total.Store(min(c.total.Load(), other.total.Load()))
+	return out, nil
+}
+
+// Combine returns a new sketch where each counter is the result of the function applied
+// to the estimates of the corresponding counters of both sketches, re-encoded to the
+// nearest counter state. The function is also applied to the totals of both sketches.
+// For example, a sum merges the sketches while a min intersects them.
+func (c *CountMin) Combine(other *CountMin, fn func(a, b uint) uint) (*CountMin, error) {
+	if err := c.compatible(other); err != nil {
+		return nil, err
+	}
+
+	out := c.empty(other)
+	for d, row := range out.counts {
+		for j := range row {
+			a, b := c.counts[d][j].Estimate(), other.counts[d][j].Estimate()
+			row[j].transform(func(lane int, _ uint16) uint16 {
+				return encode16(float64(fn(a[lane], b[lane])))
+			})
+		}
+	}
+
+	out.total.Store(uint64(fn(c.Total(), other.Total())))
+	return out, nil
+}
+
+// MergeInto adds the counts of this sketch into the destination sketch. Both sketches
+// must have the same depth, and the width of this sketch must be a multiple of the width
+// of the destination. When the widths differ, the columns are folded: since the columns
+// are derived with a multiply-shift reduction, each block of adjacent columns of the
+// wider sketch maps exactly onto a single column of the narrower one. The resulting
+// estimates are re-encoded to the nearest counter state. The rows cleared in this sketch
+// are also excluded from the destination.
+func (c *CountMin) MergeInto(dst *CountMin) error {
+	switch {
+	case dst == nil:
+		return errors.New("sketch: destination sketch should not be nil")
+	case c.depth != dst.depth:
+		return errors.New("sketch: depth of both sketches should match")
+	case c.width < dst.width || c.width%dst.width != 0:
+		return errors.New("sketch: width should be a multiple of the destination width")
+	case c.mixed != dst.mixed:
+		return errors.New("sketch: hashing of both sketches should match")
+	}
+
+	fold := c.width / dst.width
+	sums := make([]float64, dst.width)
+	for d, row := range c.counts {
+		clear(sums)
+		for j := range row {
+			for i, v := range row[j].Estimate() {
+				sums[(j*Stripe+i)/fold] += float64(v)
+			}
+		}
+
+		for j := range dst.counts[d] {
+			dst.counts[d][j].transform(func(lane int, state uint16) uint16 {
+				return encode16(n(float64(state), scale16) + sums[j*Stripe+lane])
+			})
+		}
+	}
+
+	dst.excludeFrom(c)
+	dst.total.Add(c.total.Load())
+	return nil
+}
+
+// MergeAll returns a new sketch holding the combined counts of all of the given sketches,
+// which must share the same depth and width. The estimates are accumulated across all
+// of the inputs and re-encoded once per counter, avoiding intermediate sketches and the
+// rounding that a pairwise merge would compound.
+func MergeAll(sketches []*CountMin) (*CountMin, error) {
+	if len(sketches) == 0 {
+		return nil, errors.New("sketch: at least one sketch should be provided")
+	}
+
+	var total uint64
+	for i, s := range sketches {
+		if s == nil {
+			return nil, fmt.Errorf("sketch: sketch at index %d should not be nil", i)
+		}
+		if err := sketches[0].compatible(s); err != nil {
+			return nil, fmt.Errorf("sketch: sketch at index %d should match the first sketch: %w", i, err)
+		}
+		total += s.total.Load()
+	}
+
+	out := sketches[0].empty(sketches...)
+	sums := make([]float64, out.width)
+	for d, row := range out.counts {
+		clear(sums)
+		for _, s := range sketches {
+			for j := range s.counts[d] {
+				for i, v := range s.counts[d][j].Estimate() {
+					sums[j*Stripe+i] += float64(v)
+				}
+			}
+		}
+
+		for j := range row {
+			row[j].transform(func(lane int, _ uint16) uint16 {
+				return encode16(sums[j*Stripe+lane])
+			})
+		}
+	}
+
+	out.total.Store(total)
+	return out, nil
+}
+
+// empty returns a new empty sketch with the same geometry and hashing as this one,
+// which excludes the rows cleared in this sketch or in any of the others.
+func (c *CountMin) empty(others ...*CountMin) *CountMin {
+	mx := make([][]Count16x4, c.depth)
+	for i := range mx {
+		mx[i] = make([]Count16x4, c.width/Stripe)
+	}
+
+	out := &CountMin{
+		depth:  c.depth,
+		width:  c.width,
+		counts: mx,
+		mixed:  c.mixed,
+	}
+
+	out.excludeFrom(c)
+	for _, other := range others {
+		out.excludeFrom(other)
+	}
+	return out
+}
+
+// excludeFrom also excludes the rows cleared in the other sketch. The counters of
+// these rows are left as-is since they are no longer read.
+func (c *CountMin) excludeFrom(other *CountMin) {
+	for i := range c.cleared {
+		for mask, v := &c.cleared[i], other.cleared[i].Load(); ; {
+			if x := mask.Load(); mask.CompareAndSwap(x, x|v) {
+				break
+			}
+		}
+	}
+}
+
+// compatible returns an error if the other sketch has a different geometry.
+func (c *CountMin) compatible(other *CountMin) error {
+	switch {
+	case other == nil:
+		return errors.New("sketch: other sketch should not be nil")
+	case c.depth != other.depth || c.width != other.width:
+		return errors.New("sketch: depth and width of both sketches should match")
+	case c.mixed != other.mixed:
+		return errors.New("sketch: hashing of both sketches should match")
+	default:
+		return nil
+	}
+}
+
+// CountApprox returns a cheaper but looser estimate of the frequency of the given item
+func (c *CountMin) CountApprox(item []byte) uint {
+	return c.CountApproxHash(xxh3.Hash(item))
+}
+
+// CountApproxString returns a cheaper but looser estimate of the frequency of the given item
+func (c *CountMin) CountApproxString(item string) uint {
+	return c.CountApproxHash(xxh3.HashString(item))
+}
+
+// CountApproxHash returns a cheaper but looser estimate of the frequency of the given
+// item. Only the first row of the sketch which is not cleared is consulted, so the
+// estimate is more likely to be inflated by collisions than the one returned by CountHash.
+func (c *CountMin) CountApproxHash(hash uint64) uint {
+	for i := 0; i < c.depth; i++ {
+		if !c.isCleared(i) {
+			at, lane := cell(c.counts[i], reduce(index(hash, i, c.mixed), uint64(c.width)))
+			return at.EstimateAt(lane)
+		}
+	}
+	return 0 // every row was cleared
 }
 
 // Reset sets all counters to zero
@@ -136,4 +1005,54 @@ func (c *CountMin) Reset() {
 			c.counts[d][j].Reset()
 		}
 	}
+
+	c.total.Store(0)
+	c.seq.Store(0)
+	for i := range c.cleared {
+		c.cleared[i].Store(0)
+	}
 }
+
+// Swap moves the counters and the total of the sketch into a snapshot which is returned
+// as a read-only view, leaving this sketch empty so that writers can carry on without
+// pausing. Every counter word is swapped atomically, so no increment is lost, but an
+// update racing with Swap may land in the snapshot for some rows and in this sketch for
+// others.
+func (c *CountMin) Swap() CountReader {
+	out := c.empty()
+	for d, row := range c.counts {
+		for j := range row {
+			out.counts[d][j].v.Store(row[j].v.Swap(0))
+		}
+	}
+
+	out.total.Store(c.total.Swap(0))
+	return out.ReadOnly()
+}
+
+// CountReader is a read-only view of a CountMin sketch, which can be handed to consumers
+// which should only estimate frequencies without mutating the sketch.
+type CountReader interface {
+	Count(item []byte) uint         // Count returns the estimated frequency of the given item
+	CountString(item string) uint   // CountString returns the estimated frequency of the given item
+	CountHash(hash uint64) uint     // CountHash returns the estimated frequency of the given hash
+	CountAll(items [][]byte) []uint // CountAll returns the estimated frequencies of the given items
+	Total() uint                    // Total returns the total number of observations
+}
+
+// ReadOnly returns a read-only view of the sketch. The view reflects subsequent updates
+// of the sketch, but cannot be converted back to a *CountMin.
+func (c *CountMin) ReadOnly() CountReader {
+	return readOnly{sketch: c}
+}
+
+// readOnly wraps a sketch and only exposes its read methods
+type readOnly struct {
+	sketch *CountMin
+}
+
+func (r readOnly) Count(item []byte) uint         { return r.sketch.Count(item) }
+func (r readOnly) CountString(item string) uint   { return r.sketch.CountString(item) }
+func (r readOnly) CountHash(hash uint64) uint     { return r.sketch.CountHash(hash) }
+func (r readOnly) CountAll(items [][]byte) []uint { return r.sketch.CountAll(items) }
+func (r readOnly) Total() uint                    { return r.sketch.Total() }
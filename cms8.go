@@ -0,0 +1,137 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package approx
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/zeebo/xxh3"
+)
+
+// stripe8 is the number of 8-bit counters packed in a Count8x8
+const stripe8 = 8
+
+// CountMin8 is a CountMin sketch backed by 8-bit counters, which halves the memory of
+// the sketch compared to CountMin. The counters saturate at ~100k (MaxCount8) with a
+// mean error of around ~10% per cell, so this suits high-cardinality streams where no
+// item is expected to exceed that count.
+type CountMin8 struct {
+	depth  int           // number of hash functions
+	width  int           // number of counters per hash function
+	counts [][]Count8x8  // 2D array of counters
+	total  atomic.Uint64 // total number of observations
+}
+
+// NewCountMin8 creates a new CountMin sketch backed by 8-bit counters with the given
+// depth and width. The width is rounded up to the next multiple of 8, see Width() for
+// the realized width.
+func NewCountMin8(depth, width uint) (*CountMin8, error) {
+	switch {
+	case depth%2 != 0:
+		return nil, errors.New("sketch: depth should be divisible by 2")
+	case depth > MaxDepth:
+		return nil, errors.New("sketch: depth should be at most MaxDepth (128)")
+	case width > MaxWidth:
+		return nil, errors.New("sketch: width should be at most MaxWidth")
+	}
+
+	// Round up the width to a multiple of 8, this can't overflow since MaxWidth is
+	// far below the maximum value of uint
+	width = (width + stripe8 - 1) / stripe8 * stripe8
+
+	mx := make([][]Count8x8, depth)
+	for i := range mx {
+		mx[i] = make([]Count8x8, width/stripe8)
+	}
+
+	return &CountMin8{
+		depth:  int(depth),
+		width:  int(width),
+		counts: mx,
+	}, nil
+}
+
+// cell8 resolves the flat column index of a row into the packed counter word holding
+// it and the lane of the counter within that word, since 8 counters are packed per word.
+func cell8(row []Count8x8, col int) (at *Count8x8, lane int) {
+	return &row[col/stripe8], col % stripe8
+}
+
+// Depth returns the number of hash functions (rows) of the sketch
+func (c *CountMin8) Depth() int {
+	return c.depth
+}
+
+// Width returns the number of counters per hash function (columns) of the sketch
+func (c *CountMin8) Width() int {
+	return c.width
+}
+
+// Update increments the counter for the given item
+func (c *CountMin8) Update(item []byte) bool {
+	return c.UpdateHash(xxh3.Hash(item))
+}
+
+// UpdateString increments the counter for the given item
+func (c *CountMin8) UpdateString(item string) bool {
+	return c.UpdateHash(xxh3.HashString(item))
+}
+
+// UpdateHash increments the counter for the given item
+func (c *CountMin8) UpdateHash(hash uint64) (updated bool) {
+	c.total.Add(1)
+
+	w := uint64(c.width)
+	r := roll32() // Keep same random value for all counters
+	for i := 0; i < c.depth; i++ {
+		at, lane := cell8(c.counts[i], reduce(index(hash, i, false), w))
+		if at.incrementAt(lane, r) {
+			updated = true
+		}
+	}
+
+	return updated
+}
+
+// Count returns the estimated frequency of the given item
+func (c *CountMin8) Count(item []byte) uint {
+	return c.CountHash(xxh3.Hash(item))
+}
+
+// CountString returns the estimated frequency of the given item
+func (c *CountMin8) CountString(item string) uint {
+	return c.CountHash(xxh3.HashString(item))
+}
+
+// CountHash returns the estimated frequency of the given item
+func (c *CountMin8) CountHash(hash uint64) uint {
+	x := ^uint(0)
+	w := uint64(c.width)
+	for i := 0; i < c.depth && x > 0; i++ {
+		at, lane := cell8(c.counts[i], reduce(index(hash, i, false), w))
+		x = min(x, at.EstimateAt(lane))
+	}
+
+	if x == ^uint(0) {
+		return 0 // the sketch has no rows
+	}
+	return x
+}
+
+// Total returns the total number of observations added to the sketch
+func (c *CountMin8) Total() uint {
+	return uint(c.total.Load())
+}
+
+// Reset sets all counters to zero
+func (c *CountMin8) Reset() {
+	for d, row := range c.counts {
+		for j := range row {
+			c.counts[d][j].Reset()
+		}
+	}
+
+	c.total.Store(0)
+}
@@ -0,0 +1,74 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package approx
+
+import (
+	"strconv"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountMin8(t *testing.T) {
+	c, err := NewCountMin8(4, 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, c.Depth())
+	assert.Equal(t, 1000, c.Width())
+
+	for i := 0; i < 1000; i++ {
+		c.UpdateString("foo")
+	}
+
+	assert.InDelta(t, 1000, float64(c.CountString("foo")), 750)
+	assert.Equal(t, c.CountString("foo"), c.Count([]byte("foo")))
+	assert.Equal(t, uint(0), c.CountString("bar"))
+	assert.Equal(t, uint(1000), c.Total())
+
+	c.Reset()
+	assert.Equal(t, uint(0), c.CountString("foo"))
+	assert.Equal(t, uint(0), c.Total())
+}
+
+func TestCountMin8_Invalid(t *testing.T) {
+	_, err := NewCountMin8(3, 1000)
+	assert.Error(t, err)
+
+	_, err = NewCountMin8(MaxDepth+2, 1000)
+	assert.Error(t, err)
+
+	_, err = NewCountMin8(4, MaxWidth+1)
+	assert.Error(t, err)
+
+	c, err := NewCountMin8(2, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 16, c.Width())
+}
+
+func TestCountMin8_Memory(t *testing.T) {
+	c8, err := NewCountMin8(4, 1024)
+	assert.NoError(t, err)
+
+	c16, err := NewCountMinWithSize(4, 1024)
+	assert.NoError(t, err)
+
+	size8 := len(c8.counts[0]) * int(unsafe.Sizeof(Count8x8{}))
+	size16 := len(c16.counts[0]) * int(unsafe.Sizeof(Count16x4{}))
+	assert.Equal(t, size16/2, size8)
+}
+
+func TestCountMin8_Many(t *testing.T) {
+	c, err := NewCountMin8(4, 4096)
+	assert.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		for j := 0; j < 10; j++ {
+			c.UpdateString(strconv.Itoa(i))
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		assert.InDelta(t, 10, float64(c.CountString(strconv.Itoa(i))), 8)
+	}
+}
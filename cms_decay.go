@@ -0,0 +1,143 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package approx
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/zeebo/xxh3"
+)
+
+// DecayingCountMin is a CountMin sketch whose counters decay exponentially over time,
+// which is useful for detecting trending items. Each counter remembers when it was last
+// updated and, on access, its value is decayed by exp(-lambda*dt) where lambda is derived
+// from the half-life. Unlike CountMin, each counter is stored as a float32 value along
+// with an int64 timestamp, so the sketch uses 12 bytes per counter instead of 2.
+type DecayingCountMin struct {
+	mu     sync.Mutex
+	depth  int         // number of hash functions
+	width  int         // number of counters per hash function
+	lambda float64     // decay rate per nanosecond
+	values [][]float32 // decayed value of each counter
+	ticks  [][]int64   // last update time of each counter, in unix nanoseconds
+	mixed  bool        // whether each row re-mixes the hash independently
+}
+
+// NewDecayingCountMin creates a new time-decaying CountMin sketch with the given depth
+// and width, where the counters lose half of their value every half-life.
+func NewDecayingCountMin(depth, width uint, halfLife time.Duration) (*DecayingCountMin, error) {
+	switch {
+	case depth%2 != 0:
+		return nil, errors.New("sketch: depth should be divisible by 2")
+	case depth == 0 || depth > MaxDepth:
+		return nil, errors.New("sketch: depth should be in range of [1, MaxDepth]")
+	case width == 0 || width > MaxWidth:
+		return nil, errors.New("sketch: width should be in range of [1, MaxWidth]")
+	case halfLife <= 0:
+		return nil, errors.New("sketch: half-life should be greater than zero")
+	}
+
+	values := make([][]float32, depth)
+	ticks := make([][]int64, depth)
+	for i := range values {
+		values[i] = make([]float32, width)
+		ticks[i] = make([]int64, width)
+	}
+
+	return &DecayingCountMin{
+		depth:  int(depth),
+		width:  int(width),
+		lambda: math.Ln2 / float64(halfLife),
+		values: values,
+		ticks:  ticks,
+	}, nil
+}
+
+// NewDecayingCountMinIndependent creates a new time-decaying CountMin sketch where each
+// row re-mixes the hash of the item with its own seed, see NewCountMinIndependent.
+func NewDecayingCountMinIndependent(depth, width uint, halfLife time.Duration) (*DecayingCountMin, error) {
+	c, err := NewDecayingCountMin(depth, width, halfLife)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mixed = true
+	return c, nil
+}
+
+// Update increments the counter for the given item at the given time
+func (c *DecayingCountMin) Update(item []byte, now time.Time) {
+	c.UpdateHash(xxh3.Hash(item), now)
+}
+
+// UpdateString increments the counter for the given item at the given time
+func (c *DecayingCountMin) UpdateString(item string, now time.Time) {
+	c.UpdateHash(xxh3.HashString(item), now)
+}
+
+// UpdateHash increments the counter for the given item at the given time. Each counter
+// is first decayed to the given time and then incremented.
+func (c *DecayingCountMin) UpdateHash(hash uint64, now time.Time) {
+	at := now.UnixNano()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w := uint64(c.width)
+	for i := 0; i < c.depth; i++ {
+		idx := reduce(index(hash, i, c.mixed), w)
+		c.values[i][idx] = c.decayed(i, idx, at) + 1
+		c.ticks[i][idx] = max(c.ticks[i][idx], at)
+	}
+}
+
+// Count returns the decayed frequency of the given item at the given time
+func (c *DecayingCountMin) Count(item []byte, now time.Time) float64 {
+	return c.CountHash(xxh3.Hash(item), now)
+}
+
+// CountString returns the decayed frequency of the given item at the given time
+func (c *DecayingCountMin) CountString(item string, now time.Time) float64 {
+	return c.CountHash(xxh3.HashString(item), now)
+}
+
+// CountHash returns the decayed frequency of the given item at the given time
+func (c *DecayingCountMin) CountHash(hash uint64, now time.Time) float64 {
+	at := now.UnixNano()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	x := float32(math.MaxFloat32)
+	w := uint64(c.width)
+	for i := 0; i < c.depth; i++ {
+		idx := reduce(index(hash, i, c.mixed), w)
+		x = min(x, c.decayed(i, idx, at))
+	}
+	return float64(x)
+}
+
+// decayed returns the value of the counter decayed to the given time. Times before
+// the last update of the counter are treated as the time of the last update.
+func (c *DecayingCountMin) decayed(row, col int, at int64) float32 {
+	value := c.values[row][col]
+	if dt := at - c.ticks[row][col]; dt > 0 && value > 0 {
+		value *= float32(math.Exp(-c.lambda * float64(dt)))
+	}
+	return value
+}
+
+// Reset sets all counters to zero
+func (c *DecayingCountMin) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range c.values {
+		clear(c.values[i])
+		clear(c.ticks[i])
+	}
+}
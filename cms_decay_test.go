@@ -0,0 +1,67 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package approx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecayingCountMin(t *testing.T) {
+	c, err := NewDecayingCountMin(4, 1024, time.Minute)
+	assert.NoError(t, err)
+
+	now := time.Unix(1000, 0)
+	for i := 0; i < 100; i++ {
+		c.UpdateString("a", now)
+	}
+	c.Update([]byte("b"), now)
+	assert.InDelta(t, 100, c.CountString("a", now), 0.01)
+	assert.InDelta(t, 1, c.Count([]byte("b"), now), 0.01)
+	assert.Zero(t, c.CountString("c", now))
+
+	// The count halves every half-life
+	assert.InDelta(t, 50, c.CountString("a", now.Add(time.Minute)), 0.01)
+	assert.InDelta(t, 25, c.CountString("a", now.Add(2*time.Minute)), 0.01)
+
+	// Counting does not decay the stored value, while updating does
+	c.UpdateString("a", now.Add(time.Minute))
+	assert.InDelta(t, 51, c.CountString("a", now.Add(time.Minute)), 0.01)
+
+	// Times in the past are treated as the last update
+	assert.InDelta(t, 51, c.CountString("a", now), 0.01)
+
+	c.Reset()
+	assert.Zero(t, c.CountString("a", now))
+}
+
+func TestDecayingCountMin_Validation(t *testing.T) {
+	_, err := NewDecayingCountMin(0, 1024, time.Minute)
+	assert.Error(t, err)
+	_, err = NewDecayingCountMin(4, 0, time.Minute)
+	assert.Error(t, err)
+	_, err = NewDecayingCountMin(4, 1024, 0)
+	assert.Error(t, err)
+	_, err = NewDecayingCountMin(3, 1024, time.Minute)
+	assert.Error(t, err)
+}
+
+func TestDecayingCountMin_Independent(t *testing.T) {
+	c, err := NewDecayingCountMinIndependent(4, 1024, time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, c.mixed)
+
+	now := time.Unix(1000, 0)
+	for i := 0; i < 100; i++ {
+		c.UpdateString("a", now)
+	}
+	assert.InDelta(t, 100, c.CountString("a", now), 0.01)
+	assert.InDelta(t, 50, c.CountString("a", now.Add(time.Minute)), 0.01)
+	assert.Zero(t, c.CountString("b", now))
+
+	_, err = NewDecayingCountMinIndependent(3, 1024, time.Minute)
+	assert.Error(t, err)
+}
@@ -4,17 +4,27 @@
 package approx
 
 import (
+	"encoding/binary"
+	"math"
+	"math/rand"
 	"strconv"
 	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/zeebo/xxh3"
 )
 
 /*
 cpu: 13th Gen Intel(R) Core(TM) i7-13700K
 BenchmarkCMS/update-24         	45178000	        25.74 ns/op	       0 B/op	       0 allocs/op
 BenchmarkCMS/count-24          	88864532	        13.59 ns/op	       0 B/op	       0 allocs/op
+
+cpu: AMD EPYC
+BenchmarkCMS/count             	77834816	        15.75 ns/op	       0 B/op	       0 allocs/op
+BenchmarkCMS/count-approx      	264446462	         4.434 ns/op	       0 B/op	       0 allocs/op
+BenchmarkCMS/update-uint64s         	66369672	        16.64 ns/op	       0 B/op	       0 allocs/op
+BenchmarkCMS/update-uint64s-scalar  	48604768	        23.40 ns/op	       0 B/op	       0 allocs/op
 */
 func BenchmarkCMS(b *testing.B) {
 	b.Run("update", func(b *testing.B) {
@@ -35,6 +45,55 @@ func BenchmarkCMS(b *testing.B) {
 			c.CountString("foo")
 		}
 	})
+
+	b.Run("update-count", func(b *testing.B) {
+		c, _ := NewCountMin()
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			c.UpdateAndCountString("foo")
+		}
+	})
+
+	b.Run("update-uint64s", func(b *testing.B) {
+		c, _ := NewCountMin()
+		keys := make([]uint64, 1024)
+		for i := range keys {
+			keys[i] = uint64(i)
+		}
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i += len(keys) {
+			c.UpdateUint64s(keys)
+		}
+	})
+
+	b.Run("update-uint64s-scalar", func(b *testing.B) {
+		c, _ := NewCountMin()
+		keys := make([]uint64, 1024)
+		for i := range keys {
+			keys[i] = uint64(i)
+		}
+
+		b.ResetTimer()
+		var buf [8]byte
+		for i := 0; i < b.N; i += len(keys) {
+			for _, key := range keys {
+				binary.LittleEndian.PutUint64(buf[:], key)
+				c.Update(buf[:])
+			}
+		}
+	})
+
+	b.Run("count-approx", func(b *testing.B) {
+		c, _ := NewCountMin()
+		c.UpdateString("foo")
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			c.CountApproxString("foo")
+		}
+	})
 }
 
 func TestCounter_HighCardinality(t *testing.T) {
@@ -96,6 +155,730 @@ func TestCounter_Overflow(t *testing.T) {
 	assert.InDelta(t, uint(1000), c.CountString("foo"), 100)
 }
 
+func TestCounter_Add(t *testing.T) {
+	c, err := NewCountMin()
+	assert.NoError(t, err)
+
+	assert.False(t, c.AddString("foo", 0))
+	assert.True(t, c.AddString("foo", 1))
+	assert.True(t, c.Add([]byte("bar"), 10))
+	assert.True(t, c.AddString("baz", 100000))
+
+	assert.Equal(t, uint(1), c.CountString("foo"))
+	assert.InDelta(t, 10, c.CountString("bar"), 1)
+	assert.InDelta(t, 100000, c.CountString("baz"), 1000)
+
+	// Adding in batches should approximate individual updates
+	for i := 0; i < 100; i++ {
+		c.AddString("qux", 1000)
+	}
+	assert.InDelta(t, 100000, c.CountString("qux"), 2000)
+}
+
+func TestCounter_AddAndCount(t *testing.T) {
+	c, err := NewCountMin()
+	assert.NoError(t, err)
+
+	assert.Equal(t, uint(0), c.AddAndCountString("foo", 0))
+	assert.InDelta(t, 10, c.AddAndCountString("foo", 10), 1)
+	count := c.AddAndCount([]byte("foo"), 5)
+	assert.InDelta(t, 15, count, 1)
+	assert.Equal(t, count, c.AddAndCountString("foo", 0))
+	assert.Equal(t, count, c.CountString("foo"))
+	assert.Equal(t, uint(15), c.Total())
+
+	for i := 0; i < 100; i++ {
+		count := c.AddAndCountString("bar", 1000)
+		assert.Equal(t, c.CountString("bar"), count)
+	}
+	assert.InDelta(t, 100000, c.CountString("bar"), 2000)
+}
+
+func TestCounter_SubtractCount(t *testing.T) {
+	c, err := NewCountMin()
+	assert.NoError(t, err)
+
+	c.AddString("foo", 100)
+	c.AddString("bar", 5000)
+	c.SubtractCountString("foo", 30)
+	c.SubtractCount([]byte("bar"), 1000)
+	c.SubtractCountString("foo", 0)
+	assert.InDelta(t, 70, c.CountString("foo"), 2)
+	assert.InDelta(t, 4000, c.CountString("bar"), 40)
+	assert.Equal(t, uint(4070), c.Total())
+
+	// Clamped at zero
+	c.SubtractCountString("foo", 1000)
+	assert.Equal(t, uint(0), c.CountString("foo"))
+	c.SubtractCountString("bar", 1e6)
+	assert.Equal(t, uint(0), c.Total())
+}
+
+func TestCounter_ReadOnly(t *testing.T) {
+	c, err := NewCountMin()
+	assert.NoError(t, err)
+	c.AddString("foo", 10)
+
+	r := c.ReadOnly()
+	_, ok := r.(*CountMin)
+	assert.False(t, ok)
+	count := c.CountString("foo")
+	assert.Equal(t, count, r.CountString("foo"))
+	assert.Equal(t, count, r.Count([]byte("foo")))
+	assert.Equal(t, count, r.CountHash(xxh3.HashString("foo")))
+	assert.Equal(t, []uint{count}, r.CountAll([][]byte{[]byte("foo")}))
+	assert.Equal(t, uint(10), r.Total())
+
+	// The view reflects subsequent updates
+	c.AddString("foo", 5)
+	assert.Equal(t, c.CountString("foo"), r.CountString("foo"))
+	assert.Equal(t, uint(15), r.Total())
+}
+
+func TestCounter_Deterministic(t *testing.T) {
+	replay := func() *CountMin {
+		c, err := NewCountMinDeterministic(4, 256)
+		assert.NoError(t, err)
+		for i := 0; i < 100000; i++ {
+			c.UpdateString(strconv.Itoa(i % 100))
+			c.UpdateAndCountString("foo")
+		}
+		c.AddString("bar", 12345)
+		return c
+	}
+
+	a, b := replay(), replay()
+	for d := range a.counts {
+		for j := range a.counts[d] {
+			assert.True(t, a.counts[d][j].Equal(&b.counts[d][j]))
+		}
+	}
+
+	// Accuracy is the same as with random rolls
+	assert.InDelta(t, 100000, a.CountString("foo"), 100000*0.02)
+	assert.InDelta(t, 1000, a.CountString("42"), 1000*0.05)
+
+	// The sequence restarts after a reset
+	a.Reset()
+	a.UpdateString("foo")
+	b.Reset()
+	b.UpdateString("foo")
+	assert.Equal(t, a.CountString("foo"), b.CountString("foo"))
+
+	_, err := NewCountMinDeterministic(3, 256)
+	assert.Error(t, err)
+}
+
+func TestCounter_Empty(t *testing.T) {
+	c, err := NewCountMin()
+	assert.NoError(t, err)
+
+	// Empty items are counted by default, nil and empty share the same hash
+	assert.True(t, c.Update(nil))
+	assert.True(t, c.Update([]byte{}))
+	assert.True(t, c.UpdateString(""))
+	assert.Equal(t, uint(3), c.Count(nil))
+	assert.Equal(t, uint(3), c.CountString(""))
+	assert.Equal(t, uint(3), c.Total())
+
+	// Empty items are ignored when skipped
+	s, err := NewCountMinSkipEmpty(4, 1024)
+	assert.NoError(t, err)
+	assert.False(t, s.Update(nil))
+	assert.False(t, s.UpdateString(""))
+	assert.False(t, s.Add([]byte{}, 10))
+	assert.False(t, s.AddString("", 10))
+	assert.Zero(t, s.UpdateAndCount(nil))
+	assert.Zero(t, s.UpdateAndCountString(""))
+	assert.Zero(t, s.AddAndCount(nil, 5))
+	assert.Zero(t, s.AddAndCountString("", 5))
+	assert.Zero(t, s.CountString(""))
+	assert.Zero(t, s.Total())
+
+	// Other items are still counted
+	assert.True(t, s.UpdateString("foo"))
+	assert.Equal(t, uint(1), s.CountString("foo"))
+
+	_, err = NewCountMinSkipEmpty(3, 1024)
+	assert.Error(t, err)
+}
+
+func TestCounter_UpdateUint64s(t *testing.T) {
+	var buf [8]byte
+	for _, key := range []uint64{0, 1, 42, 1 << 32, math.MaxUint64} {
+		binary.LittleEndian.PutUint64(buf[:], key)
+		assert.Equal(t, xxh3.Hash(buf[:]), hashUint64(key))
+	}
+
+	c, err := NewCountMin()
+	assert.NoError(t, err)
+
+	keys := make([]uint64, 0, 1000)
+	for i := 0; i < 100; i++ {
+		for j := 0; j < 10; j++ {
+			keys = append(keys, uint64(i))
+		}
+	}
+
+	c.UpdateUint64s(keys)
+	c.UpdateUint64s(nil)
+	assert.Equal(t, uint(1000), c.Total())
+	for i := 0; i < 100; i++ {
+		binary.LittleEndian.PutUint64(buf[:], uint64(i))
+		assert.InDelta(t, 10, c.CountUint64(uint64(i)), 2)
+		assert.Equal(t, c.Count(buf[:]), c.CountUint64(uint64(i)))
+	}
+}
+
+func TestCounter_CountWithRow(t *testing.T) {
+	c, err := NewCountMinWithSize(4, 1024)
+	assert.NoError(t, err)
+	c.AddString("foo", 10)
+	expect := c.CountString("foo")
+
+	// All rows tie, so the first one is returned
+	estimate, row := c.CountWithRow([]byte("foo"))
+	assert.Equal(t, expect, estimate)
+	assert.Equal(t, 0, row)
+
+	// The cleared rows are skipped
+	c.ClearRow(0)
+	c.ClearRow(1)
+	estimate, row = c.CountWithRow([]byte("foo"))
+	assert.Equal(t, expect, estimate)
+	assert.Equal(t, 2, row)
+
+	// Inflate the cell of the item in the third row, the fourth row has the minimum
+	hash := xxh3.HashString("foo")
+	hx := (hash & (1<<32 - 1)) + 2*(hash>>32)
+	at, lane := cell(c.counts[2], reduce(hx, uint64(c.width)))
+	at.IncrementAtN(lane, 100)
+	estimate, row = c.CountWithRowHash(hash)
+	assert.Equal(t, expect, estimate)
+	assert.Equal(t, 3, row)
+
+	c.ClearRow(2)
+	c.ClearRow(3)
+	estimate, row = c.CountWithRow([]byte("foo"))
+	assert.Zero(t, estimate)
+	assert.Equal(t, -1, row)
+}
+
+func TestCounter_Independent(t *testing.T) {
+	maxError := func(c *CountMin) (worst uint) {
+		const base = 1 << 20 // same column of every row with double hashing
+
+		// Crafted hashes with a zero upper half, all of them in the same column
+		for k := uint64(0); k < 100; k++ {
+			c.AddHash(base+k, 10)
+		}
+
+		// None of the victims were added, so their estimate is the error
+		for k := uint64(100); k < 200; k++ {
+			worst = max(worst, c.CountHash(base+k))
+		}
+		return worst
+	}
+
+	linear, _ := NewCountMinWithSize(4, 1024)
+	mixed, err := NewCountMinIndependent(4, 1024)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, maxError(linear), uint(900))
+	assert.LessOrEqual(t, maxError(mixed), uint(50))
+
+	// Independent sketches still count, and only combine with each other
+	mixed.AddString("foo", 100)
+	assert.InDelta(t, 100, mixed.CountString("foo"), 2)
+	assert.Error(t, linear.MergeInto(mixed))
+	_, err = linear.Intersect(mixed)
+	assert.Error(t, err)
+	_, err = MergeAll([]*CountMin{mixed, linear})
+	assert.Error(t, err)
+
+	merged, err := MergeAll([]*CountMin{mixed, mixed})
+	assert.NoError(t, err)
+	assert.InDelta(t, 200, merged.CountString("foo"), 4)
+
+	_, err = NewCountMinIndependent(3, 1024)
+	assert.Error(t, err)
+}
+
+func TestCounter_Fill(t *testing.T) {
+	c, err := NewCountMin()
+	assert.NoError(t, err)
+
+	c.Fill(map[string]uint{
+		"foo": 2,
+		"bar": 10,
+		"baz": 0,
+	})
+
+	assert.Equal(t, uint(2), c.CountString("foo"))
+	assert.InDelta(t, 10, c.CountString("bar"), 1)
+	assert.Equal(t, uint(0), c.CountString("baz"))
+
+	// Empty items are ignored when the sketch skips them
+	skip, err := NewCountMinSkipEmpty(4, 1024)
+	assert.NoError(t, err)
+	skip.Fill(map[string]uint{"": 5, "foo": 2})
+	assert.Zero(t, skip.CountString(""))
+	assert.Equal(t, uint(2), skip.Total())
+}
+
+func TestCounter_Scale(t *testing.T) {
+	c, err := NewCountMin()
+	assert.NoError(t, err)
+
+	c.AddString("foo", 10000)
+	c.AddString("bar", 10)
+	foo, bar := c.CountString("foo"), c.CountString("bar")
+
+	c.Scale(2)
+	assert.InDelta(t, 2*foo, c.CountString("foo"), float64(foo)*0.01)
+	assert.Equal(t, 2*bar, c.CountString("bar"))
+
+	c.Scale(0.5)
+	assert.InDelta(t, foo, c.CountString("foo"), float64(foo)*0.01)
+	assert.Equal(t, bar, c.CountString("bar"))
+
+	c.Scale(-1)
+	assert.Equal(t, uint(0), c.CountString("foo"))
+}
+
+func TestCounter_Subtract(t *testing.T) {
+	added, _ := NewCountMin()
+	removed, _ := NewCountMin()
+
+	added.AddString("foo", 100)
+	added.AddString("bar", 10)
+	removed.AddString("foo", 30)
+	removed.AddString("bar", 20)
+	removed.AddString("baz", 5)
+
+	assert.NoError(t, added.Subtract(removed))
+	assert.InDelta(t, 70, added.CountString("foo"), 1)
+	assert.Equal(t, uint(0), added.CountString("bar"))
+	assert.Equal(t, uint(0), added.CountString("baz"))
+
+	// Geometry must match
+	other, _ := NewCountMinWithSize(2, 1024)
+	assert.Error(t, added.Subtract(other))
+	assert.Error(t, added.Subtract(nil))
+}
+
+func TestCounter_CountApprox(t *testing.T) {
+	c, err := NewCountMin()
+	assert.NoError(t, err)
+
+	for i := 0; i < 1000; i++ {
+		c.UpdateString(strconv.Itoa(i))
+	}
+
+	// The single-row estimate is never tighter than the full estimate
+	for i := 0; i < 1000; i++ {
+		item := strconv.Itoa(i)
+		assert.GreaterOrEqual(t, c.CountApproxString(item), c.CountString(item))
+		assert.Equal(t, c.CountApproxString(item), c.CountApprox([]byte(item)))
+	}
+}
+
+func TestCounter_ForEachCell(t *testing.T) {
+	c, err := NewCountMinWithSize(2, 8)
+	assert.NoError(t, err)
+
+	// Seed each cell with its own small state, whose estimate is exactly the state
+	for d, row := range c.counts {
+		for j := range row {
+			row[j].transform(func(lane int, _ uint16) uint16 {
+				return uint16(d*c.Width() + j*Stripe + lane + 1)
+			})
+		}
+	}
+
+	seen := make(map[[2]int]bool)
+	c.ForEachCell(func(row, col int, estimate uint) {
+		assert.Equal(t, uint(row*8+col+1), estimate)
+		assert.False(t, seen[[2]int{row, col}])
+		seen[[2]int{row, col}] = true
+	})
+
+	assert.Len(t, seen, 16)
+}
+
+func TestCounter_Utilization(t *testing.T) {
+	c, err := NewCountMinWithSize(2, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, c.Utilization())
+
+	c.AddString("foo", 1000)
+	assert.Equal(t, 0.0, c.Utilization())
+
+	c.AddString("foo", math.MaxUint32)
+	assert.Equal(t, 2.0/16, c.Utilization())
+}
+
+func TestCounter_Frequency(t *testing.T) {
+	c, err := NewCountMin()
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, c.FrequencyString("foo"))
+
+	c.AddString("foo", 30)
+	c.AddString("bar", 60)
+	c.UpdateString("baz")
+	c.Update([]byte("baz"))
+	c.AddHash(xxh3.HashString("baz"), 8)
+	assert.Equal(t, uint(100), c.Total())
+
+	assert.InDelta(t, 0.3, c.FrequencyString("foo"), 0.02)
+	assert.InDelta(t, 0.6, c.Frequency([]byte("bar")), 0.02)
+	assert.InDelta(t, 0.1, c.FrequencyHash(xxh3.HashString("baz")), 0.02)
+	assert.Equal(t, 0.0, c.FrequencyString("qux"))
+
+	c.Reset()
+	assert.Equal(t, uint(0), c.Total())
+	assert.Equal(t, 0.0, c.FrequencyString("foo"))
+}
+
+func TestCounter_UpdateAndCount(t *testing.T) {
+	c, err := NewCountMin()
+	assert.NoError(t, err)
+
+	for i := 0; i < 1000; i++ {
+		c.UpdateString(strconv.Itoa(i % 10))
+	}
+
+	for i := 0; i < 10; i++ {
+		item := strconv.Itoa(i)
+		count := c.UpdateAndCountString(item)
+		assert.Equal(t, c.CountString(item), count)
+		assert.InDelta(t, 101, count, 8)
+	}
+
+	assert.Equal(t, uint(1), c.UpdateAndCount([]byte("foo")))
+	assert.Equal(t, uint(1), c.UpdateAndCountHash(xxh3.HashString("bar")))
+	assert.Equal(t, uint(1012), c.Total())
+}
+
+func TestCounter_CountHashBatch(t *testing.T) {
+	c, err := NewCountMin()
+	assert.NoError(t, err)
+	c.AddString("foo", 10)
+	c.AddString("bar", 20)
+
+	hashes := []uint64{xxh3.HashString("bar"), xxh3.HashString("baz"), xxh3.HashString("foo")}
+	assert.Equal(t, []uint{c.CountString("bar"), 0, c.CountString("foo")}, c.CountHashBatch(hashes))
+	assert.Empty(t, c.CountHashBatch(nil))
+}
+
+func TestCounter_CountAll(t *testing.T) {
+	c, err := NewCountMin()
+	assert.NoError(t, err)
+	c.AddString("foo", 10)
+	c.AddString("bar", 20)
+
+	expect := []uint{c.CountString("bar"), 0, c.CountString("foo")}
+	assert.Equal(t, expect, c.CountAllString([]string{"bar", "baz", "foo"}))
+	assert.Equal(t, expect, c.CountAll([][]byte{[]byte("bar"), []byte("baz"), []byte("foo")}))
+	assert.Empty(t, c.CountAll(nil))
+	assert.Empty(t, c.CountAllString(nil))
+}
+
+func TestCounter_MergeAll(t *testing.T) {
+	shards := make([]*CountMin, 60)
+	for i := range shards {
+		shards[i], _ = NewCountMinWithSize(4, 1024)
+		for j := 0; j < 100; j++ {
+			shards[i].AddString(strconv.Itoa(j), uint(j+1))
+		}
+	}
+
+	out, err := MergeAll(shards)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, out.Depth())
+	assert.Equal(t, 1024, out.Width())
+	assert.Equal(t, uint(60*101*50), out.Total())
+	for j := 0; j < 100; j++ {
+		expect := float64(j+1) * 60
+		assert.InDelta(t, expect, out.CountString(strconv.Itoa(j)), expect*0.1+10)
+	}
+
+	// Inputs are left untouched
+	assert.Equal(t, uint(101*50), shards[0].Total())
+
+	// Invalid inputs report the offending index
+	other, _ := NewCountMinWithSize(4, 2048)
+	_, err = MergeAll([]*CountMin{shards[0], shards[1], other})
+	assert.ErrorContains(t, err, "index 2")
+	_, err = MergeAll([]*CountMin{shards[0], nil})
+	assert.ErrorContains(t, err, "index 1")
+	_, err = MergeAll(nil)
+	assert.Error(t, err)
+}
+
+func TestCounter_MergeInto(t *testing.T) {
+	wide, _ := NewCountMinWithSize(4, 4096)
+	narrow, _ := NewCountMinWithSize(4, 1024)
+	for i := 0; i < 100; i++ {
+		wide.AddString(strconv.Itoa(i), uint(i+1)*10)
+		narrow.AddString(strconv.Itoa(i), 5)
+	}
+
+	assert.NoError(t, wide.MergeInto(narrow))
+	assert.Equal(t, uint(100*5+101*50*10), narrow.Total())
+	for i := 0; i < 100; i++ {
+		expect := float64(i+1)*10 + 5
+		assert.InDelta(t, expect, narrow.CountString(strconv.Itoa(i)), expect*0.05+5)
+	}
+
+	// Same width is a plain merge
+	same, _ := NewCountMinWithSize(4, 1024)
+	assert.NoError(t, narrow.MergeInto(same))
+	assert.InDelta(t, narrow.CountString("50"), same.CountString("50"), 2)
+
+	// Incompatible geometries
+	other, _ := NewCountMinWithSize(4, 1000)
+	assert.Error(t, wide.MergeInto(other))
+	assert.Error(t, narrow.MergeInto(wide))
+	other, _ = NewCountMinWithSize(2, 1024)
+	assert.Error(t, wide.MergeInto(other))
+	assert.Error(t, wide.MergeInto(nil))
+}
+
+func TestCounter_ClearRow(t *testing.T) {
+	c, err := NewCountMinWithSize(4, 64)
+	assert.NoError(t, err)
+
+	for i := 0; i < 1000; i++ {
+		c.UpdateString(strconv.Itoa(i % 100))
+	}
+
+	// Clearing a row keeps the estimates based on the remaining rows
+	before := c.CountString("42")
+	c.ClearRow(1)
+	c.ClearRow(-1)
+	c.ClearRow(4)
+	assert.GreaterOrEqual(t, c.CountString("42"), before)
+	assert.GreaterOrEqual(t, c.CountString("42"), uint(10))
+	c.ForEachCell(func(row, _ int, estimate uint) {
+		if row == 1 {
+			assert.Zero(t, estimate)
+		}
+	})
+
+	// Cleared rows are no longer updated
+	c.UpdateString("42")
+	c.AddString("42", 10)
+	assert.GreaterOrEqual(t, c.UpdateAndCountString("42"), uint(20))
+	c.ForEachCell(func(row, _ int, estimate uint) {
+		if row == 1 {
+			assert.Zero(t, estimate)
+		}
+	})
+
+	// With every row cleared, there is nothing to estimate from
+	for i := 0; i < c.Depth(); i++ {
+		c.ClearRow(i)
+	}
+	assert.Zero(t, c.CountString("42"))
+	assert.Zero(t, c.UpdateAndCountString("42"))
+
+	// Reset includes the rows again
+	c.Reset()
+	c.UpdateString("42")
+	assert.Equal(t, uint(1), c.CountString("42"))
+}
+
+func TestCounter_ClearRowCombine(t *testing.T) {
+	c, err := NewCountMinWithSize(4, 64)
+	assert.NoError(t, err)
+	c.AddString("foo", 10)
+	c.ClearRow(0)
+	count := c.CountString("foo")
+	assert.NotZero(t, count)
+	assert.Equal(t, count, c.CountApproxString("foo"))
+
+	// Cleared rows of either input stay excluded from the result
+	inter, err := c.Intersect(c)
+	assert.NoError(t, err)
+	assert.Equal(t, count, inter.CountString("foo"))
+
+	other, err := NewCountMinWithSize(4, 64)
+	assert.NoError(t, err)
+	other.AddString("foo", 10)
+	merged, err := MergeAll([]*CountMin{other, c})
+	assert.NoError(t, err)
+	assert.True(t, merged.isCleared(0))
+	assert.InDelta(t, count+other.CountString("foo"), merged.CountString("foo"), 2)
+
+	sum, err := other.Combine(c, func(a, b uint) uint { return a + b })
+	assert.NoError(t, err)
+	assert.True(t, sum.isCleared(0))
+	assert.NotZero(t, sum.CountString("foo"))
+
+	assert.NoError(t, c.MergeInto(other))
+	assert.True(t, other.isCleared(0))
+	assert.Equal(t, c.Swap().CountString("foo"), count)
+}
+
+func TestCounter_RowLoad(t *testing.T) {
+	c, err := NewCountMinWithSize(4, 64)
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{0, 0, 0, 0}, c.RowLoad())
+
+	c.UpdateString("a")
+	assert.Equal(t, []float64{1. / 64, 1. / 64, 1. / 64, 1. / 64}, c.RowLoad())
+
+	for i := 0; i < 10000; i++ {
+		c.UpdateString(strconv.Itoa(i))
+	}
+	for _, load := range c.RowLoad() {
+		assert.Equal(t, 1.0, load)
+	}
+}
+
+func TestCounter_SizeBytes(t *testing.T) {
+	small, _ := NewCountMinWithSize(2, 64)
+	large, _ := NewCountMinWithSize(4, 1024)
+	assert.GreaterOrEqual(t, small.SizeBytes(), 2*64*2)
+	assert.GreaterOrEqual(t, large.SizeBytes(), 4*1024*2)
+	assert.Less(t, large.SizeBytes(), 4*1024*2+1024)
+}
+
+func TestCounter_Intersect(t *testing.T) {
+	a, _ := NewCountMinWithSize(4, 1024)
+	b, _ := NewCountMinWithSize(4, 1024)
+	a.AddString("both", 100)
+	b.AddString("both", 40)
+	a.AddString("only-a", 50)
+	b.AddString("only-b", 70)
+
+	out, err := a.Intersect(b)
+	assert.NoError(t, err)
+	assert.Equal(t, b.CountString("both"), out.CountString("both"))
+	assert.Zero(t, out.CountString("only-a"))
+	assert.Zero(t, out.CountString("only-b"))
+	assert.Equal(t, uint(110), out.Total())
+
+	// Inputs are left untouched
+	assert.Equal(t, uint(150), a.Total())
+	assert.InDelta(t, 50, a.CountString("only-a"), 1)
+
+	// Geometry must match
+	other, _ := NewCountMinWithSize(2, 1024)
+	_, err = a.Intersect(other)
+	assert.Error(t, err)
+	_, err = a.Intersect(nil)
+	assert.Error(t, err)
+}
+
+func TestCounter_Combine(t *testing.T) {
+	a, _ := NewCountMinWithSize(4, 1024)
+	b, _ := NewCountMinWithSize(4, 1024)
+	a.AddString("both", 100)
+	b.AddString("both", 40)
+	a.AddString("only-a", 50)
+	b.AddString("only-b", 70)
+
+	// Sum merges the sketches
+	sum, err := a.Combine(b, func(x, y uint) uint { return x + y })
+	assert.NoError(t, err)
+	assert.InDelta(t, 140, sum.CountString("both"), 5)
+	assert.InDelta(t, 50, sum.CountString("only-a"), 1)
+	assert.InDelta(t, 70, sum.CountString("only-b"), 1)
+	assert.Equal(t, uint(260), sum.Total())
+
+	// Min intersects the sketches
+	both, err := a.Combine(b, func(x, y uint) uint { return min(x, y) })
+	assert.NoError(t, err)
+	assert.InDelta(t, 40, both.CountString("both"), 1)
+	assert.Zero(t, both.CountString("only-a"))
+	assert.Zero(t, both.CountString("only-b"))
+	assert.Equal(t, uint(110), both.Total())
+
+	// Geometry must match
+	other, _ := NewCountMinWithSize(2, 1024)
+	_, err = a.Combine(other, func(x, y uint) uint { return x })
+	assert.Error(t, err)
+}
+
+func TestCounter_FromCells(t *testing.T) {
+	cells := make([][]Count16x4, 2)
+	for i := range cells {
+		cells[i] = make([]Count16x4, 16)
+	}
+
+	c, err := NewCountMinFromCells(2, 64, cells)
+	assert.NoError(t, err)
+	assert.Equal(t, 64, c.Width())
+	assert.Equal(t, 2, c.Depth())
+
+	// The cells are shared with the sketch
+	c.UpdateString("a")
+	var sum uint
+	for _, row := range cells {
+		for i := range row {
+			sum += row[i].Sum()
+		}
+	}
+	assert.Equal(t, uint(2), sum)
+
+	// Dimensions are validated
+	_, err = NewCountMinFromCells(3, 64, cells)
+	assert.Error(t, err)
+	_, err = NewCountMinFromCells(130, 64, cells)
+	assert.Error(t, err)
+	_, err = NewCountMinFromCells(2, 62, cells)
+	assert.Error(t, err)
+	_, err = NewCountMinFromCells(4, 64, cells)
+	assert.Error(t, err)
+	_, err = NewCountMinFromCells(2, 128, cells)
+	assert.Error(t, err)
+}
+
+func TestCounter_ResetIfSaturated(t *testing.T) {
+	c, err := NewCountMinWithSize(2, 4)
+	assert.NoError(t, err)
+
+	c.AddString("a", 100)
+	assert.False(t, c.ResetIfSaturated(0))
+	assert.Equal(t, uint(100), c.Total())
+
+	// Saturate every counter
+	for i := 0; i < 100; i++ {
+		c.AddString(strconv.Itoa(i), MaxCount16)
+	}
+	assert.False(t, c.ResetIfSaturated(1))
+	assert.True(t, c.ResetIfSaturated(0.5))
+	assert.Zero(t, c.Total())
+	assert.Zero(t, c.Utilization())
+}
+
+func TestCounter_WithMemory(t *testing.T) {
+	for _, budget := range []int{1 << 10, 1 << 16, 1 << 20} {
+		c, err := NewCountMinWithMemory(budget)
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, c.SizeBytes(), budget)
+		assert.Greater(t, c.SizeBytes(), budget*9/10)
+		assert.Zero(t, c.Width()%Stripe)
+	}
+
+	c, err := NewCountMinWithMemory(1 << 20)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, c.Depth())
+	assert.InDelta(t, math.E/float64(c.Width()), c.Epsilon(), 1e-12)
+	assert.InDelta(t, 0.98, c.Confidence(), 0.01)
+
+	_, err = NewCountMinWithMemory(10)
+	assert.Error(t, err)
+}
+
+func TestCounter_Estimates(t *testing.T) {
+	c, err := NewCountMinWithEstimates(0.001, 0.99)
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, c.Epsilon(), 0.001)
+	assert.GreaterOrEqual(t, c.Confidence(), 0.99)
+}
+
 func TestCounter_Validation(t *testing.T) {
 	_, err := NewCountMinWithEstimates(0, 0)
 	assert.Error(t, err)
@@ -108,6 +891,13 @@ func TestCounter_Validation(t *testing.T) {
 
 	_, err = NewCountMinWithSize(1, 1<<31)
 	assert.Error(t, err)
+
+	// Limits are inclusive
+	c, err := NewCountMinWithSize(MaxDepth, Stripe)
+	assert.NoError(t, err)
+	assert.Equal(t, MaxDepth, c.Depth())
+	_, err = NewCountMinWithSize(MaxDepth+2, Stripe)
+	assert.Error(t, err)
 }
 
 func TestCounterParallel(t *testing.T) {
@@ -133,8 +923,136 @@ func TestCounterParallel(t *testing.T) {
 	wg.Wait()
 }
 
+func TestCountMin_Indexing(t *testing.T) {
+	c, err := NewCountMin()
+	assert.NoError(t, err)
+
+	// A fixed hash must always map to the same cells, regardless of the platform
+	c.UpdateHash(0x123456789abcdef0)
+	for row, col := range []int{618, 691, 764, 837} {
+		assert.Equal(t, uint(1), c.counts[row][col/Stripe].EstimateAt(col%Stripe))
+	}
+}
+
+func TestCountMin_Rounding(t *testing.T) {
+	c, err := NewCountMinWithEstimates(0.001, 0.99)
+	assert.NoError(t, err)
+	assert.Equal(t, 2720, c.Width())
+	assert.Equal(t, 6, c.Depth())
+
+	c.UpdateString("foo")
+	assert.Equal(t, uint(1), c.CountString("foo"))
+
+	c, err = NewCountMinWithSize(2, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, c.Width())
+	assert.Len(t, c.counts[0], 1)
+}
+
+func TestCountMin_UpdateWithRoll(t *testing.T) {
+	c, err := NewCountMin()
+	assert.NoError(t, err)
+
+	// A counter at zero is always incremented, regardless of the roll
+	const hash = 0x123456789abcdef0
+	assert.True(t, c.updateHashWithRoll(hash, 0.9999))
+	for row, col := range []int{618, 691, 764, 837} {
+		assert.Equal(t, uint64(1)<<(16*(col%Stripe)), c.counts[row][col/Stripe].v.Load())
+	}
+
+	// A high roll fails to increment, a low roll always succeeds
+	assert.False(t, c.updateHashWithRoll(hash, 0.9999))
+	assert.Equal(t, uint(1), c.CountHash(hash))
+	assert.True(t, c.updateHashWithRoll(hash, 0))
+	assert.Equal(t, uint(2), c.CountHash(hash))
+	assert.Equal(t, uint(3), c.Total())
+}
+
+func TestCountMin_Distribution(t *testing.T) {
+	const width, n = 256, 256 * 1000
+	var columns [width]int
+	for i := 0; i < n; i++ {
+		columns[reduce(rand.Uint64(), width)]++
+	}
+
+	for _, v := range columns {
+		assert.InDelta(t, n/width, v, n/width*0.2)
+	}
+}
+
+func TestCountMin_MaxWidth(t *testing.T) {
+	assert.Equal(t, 0, int(MaxWidth%Stripe))
+	assert.Equal(t, MaxWidth-1, reduce(math.MaxUint32, MaxWidth))
+	assert.Equal(t, 0, reduce(0, MaxWidth))
+	assert.Equal(t, MaxWidth/2, reduce(1<<31, MaxWidth))
+
+	_, err := NewCountMinWithSize(2, MaxWidth+1)
+	assert.Error(t, err)
+}
+
+func FuzzCountMin(f *testing.F) {
+	c, err := NewCountMinWithSize(8, 1020)
+	assert.NoError(f, err)
+
+	f.Add(uint64(0))
+	f.Add(uint64(math.MaxUint64))
+	f.Add(uint64(1 << 63))
+	f.Add(uint64(0x123456789abcdef0))
+	f.Fuzz(func(t *testing.T, hash uint64) {
+		assert.NotPanics(t, func() {
+			c.AddHash(hash, 10) // rounds to one of the neighbouring states
+			assert.GreaterOrEqual(t, c.CountHash(hash), uint(9))
+			assert.GreaterOrEqual(t, c.CountApproxHash(hash), uint(9))
+		})
+	})
+}
+
 func TestCountMin_Size(t *testing.T) {
 	c, err := NewCountMin()
 	assert.NoError(t, err)
 	assert.Equal(t, 256, len(c.counts[0]))
 }
+
+func TestCounter_Swap(t *testing.T) {
+	c, err := NewCountMinIndependent(4, 256)
+	assert.NoError(t, err)
+	c.AddString("foo", 100)
+	count := c.CountString("foo")
+
+	old := c.Swap()
+	assert.Equal(t, count, old.CountString("foo"))
+	assert.Equal(t, uint(100), old.Total())
+	assert.Equal(t, uint(0), c.CountString("foo"))
+	assert.Equal(t, uint(0), c.Total())
+	_, ok := old.(*CountMin)
+	assert.False(t, ok)
+
+	// Writers carry on with the fresh counters, leaving the snapshot untouched
+	c.AddString("foo", 5)
+	assert.Equal(t, count, old.CountString("foo"))
+	assert.Equal(t, uint(5), c.Total())
+}
+
+func TestCounter_SwapConcurrent(t *testing.T) {
+	c, err := NewCountMin()
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10000; j++ {
+				c.UpdateString(strconv.Itoa(j % 10))
+			}
+		}()
+	}
+
+	var total uint
+	for i := 0; i < 10; i++ {
+		total += c.Swap().Total()
+	}
+
+	wg.Wait()
+	assert.Equal(t, uint(40000), total+c.Swap().Total())
+}
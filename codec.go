@@ -0,0 +1,342 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package approx
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+
+	"github.com/axiomhq/hyperloglog"
+)
+
+// version is the version of the binary encoding
+const version = 1
+
+var errInvalidEncoding = errors.New("approx: invalid binary encoding")
+
+// maxTopK is the largest k of a top-k that can be encoded, which bounds the memory that
+// decoding an untrusted payload may allocate for the heap
+const maxTopK = 1 << 24
+
+// topValueSize is the minimum number of encoded bytes of a top-k element
+const topValueSize = 8 + 8 + 4 + 4
+
+// Flags set in the encoded depth of a sketch
+const (
+	mixedFlag   = 1 << 31 // the rows are mixed independently
+	clearedFlag = 1 << 30 // the masks of the cleared rows follow the total
+)
+
+// ------------------------------------ Count16x4 ------------------------------------
+
+// MarshalBinary encodes the packed counters as a single little-endian 8-byte word.
+func (c *Count16x4) MarshalBinary() ([]byte, error) {
+	return binary.LittleEndian.AppendUint64(make([]byte, 0, 8), c.v.Load()), nil
+}
+
+// UnmarshalBinary decodes the packed counters from a little-endian 8-byte word.
+func (c *Count16x4) UnmarshalBinary(data []byte) error {
+	if len(data) != 8 {
+		return errInvalidEncoding
+	}
+
+	c.v.Store(binary.LittleEndian.Uint64(data))
+	return nil
+}
+
+// ------------------------------------ CountMin ------------------------------------
+
+// MarshalBinary encodes the sketch into a binary form.
+func (c *CountMin) MarshalBinary() ([]byte, error) {
+	out := make([]byte, 0, 33+c.depth*c.width*2)
+	depth := uint32(c.depth)
+	if c.mixed {
+		depth |= mixedFlag
+	}
+
+	var cleared [MaxDepth / 64]uint64
+	for i := range cleared {
+		if cleared[i] = c.cleared[i].Load(); cleared[i] != 0 {
+			depth |= clearedFlag
+		}
+	}
+
+	out = append(out, version)
+	out = binary.LittleEndian.AppendUint32(out, depth)
+	out = binary.LittleEndian.AppendUint32(out, uint32(c.width))
+	out = binary.LittleEndian.AppendUint64(out, c.total.Load())
+	if depth&clearedFlag != 0 {
+		for _, mask := range cleared {
+			out = binary.LittleEndian.AppendUint64(out, mask)
+		}
+	}
+	for _, row := range c.counts {
+		for j := range row {
+			out = binary.LittleEndian.AppendUint64(out, row[j].v.Load())
+		}
+	}
+	return out, nil
+}
+
+// UnmarshalBinary decodes the sketch from its binary form, replacing its geometry,
+// counters and cleared rows. The deterministic and skip-empty modes are not encoded, so
+// the decoded sketch uses random rolls and counts empty items. It must not be called
+// concurrently with other methods of the sketch.
+func (c *CountMin) UnmarshalBinary(data []byte) error {
+	r := reader(data)
+	if r.byte() != version {
+		return errInvalidEncoding
+	}
+
+	depth, width, total := r.uint32(), r.uint32(), r.uint64()
+	mixed := depth&mixedFlag != 0
+
+	var cleared [MaxDepth / 64]uint64
+	if depth&clearedFlag != 0 {
+		for i := range cleared {
+			cleared[i] = r.uint64()
+		}
+	}
+
+	depth &^= mixedFlag | clearedFlag
+	switch {
+	case r == nil || depth > MaxDepth || width%Stripe != 0:
+		return errInvalidEncoding
+	case uint64(len(r)) != uint64(depth)*uint64(width/Stripe)*8:
+		return errInvalidEncoding
+	}
+
+	decoded, err := NewCountMinWithSize(uint(depth), uint(width))
+	if err != nil {
+		return err
+	}
+
+	for _, row := range decoded.counts {
+		for j := range row {
+			row[j].v.Store(r.uint64())
+		}
+	}
+
+	c.depth = decoded.depth
+	c.width = decoded.width
+	c.counts = decoded.counts
+	c.mixed = mixed
+	c.pseudo = false
+	c.skip = false
+	c.total.Store(total)
+	c.seq.Store(0)
+	for i := range cleared {
+		c.cleared[i].Store(cleared[i])
+	}
+	return nil
+}
+
+// GobEncode encodes the sketch for encoding/gob, using its binary form.
+func (c *CountMin) GobEncode() ([]byte, error) {
+	return c.MarshalBinary()
+}
+
+// GobDecode decodes the sketch for encoding/gob, using its binary form.
+func (c *CountMin) GobDecode(data []byte) error {
+	return c.UnmarshalBinary(data)
+}
+
+// MarshalJSON encodes a summary of the sketch as JSON for inspection, with its depth,
+// width and total count. This is one-directional, see Verbose for the counters.
+func (c *CountMin) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.dump(false))
+}
+
+// Verbose returns a JSON marshaler of the sketch which, in addition to the summary,
+// includes the decoded estimate of every counter. The output grows with depth*width,
+// so this is meant for debugging small sketches.
+func (c *CountMin) Verbose() json.Marshaler {
+	return verboseCountMin{c}
+}
+
+// verboseCountMin marshals the sketch with its decoded estimate matrix
+type verboseCountMin struct {
+	*CountMin
+}
+
+// MarshalJSON encodes the sketch, including its estimate matrix, as JSON.
+func (v verboseCountMin) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.dump(true))
+}
+
+// dump returns the JSON representation of the sketch
+func (c *CountMin) dump(verbose bool) any {
+	out := struct {
+		Depth     int      `json:"depth"`
+		Width     int      `json:"width"`
+		Total     uint     `json:"total"`
+		Estimates [][]uint `json:"estimates,omitempty"`
+	}{
+		Depth: c.depth,
+		Width: c.width,
+		Total: c.Total(),
+	}
+
+	if verbose {
+		out.Estimates = make([][]uint, c.depth)
+		for d, row := range c.counts {
+			out.Estimates[d] = make([]uint, 0, c.width)
+			for j := range row {
+				est := row[j].Estimate()
+				out.Estimates[d] = append(out.Estimates[d], est[:]...)
+			}
+		}
+	}
+	return out
+}
+
+// ------------------------------------ TopK ------------------------------------
+
+// MarshalBinary encodes the top-k elements, the Count-Min Sketch and the HyperLogLog
+// into a binary form. A top-k with k above 2^24 can't be encoded.
+func (t *TopK) MarshalBinary() ([]byte, error) {
+	t.mu.Lock()
+	t.cmu.Lock()
+	defer t.mu.Unlock()
+	defer t.cmu.Unlock()
+	if cap(t.heap) > maxTopK {
+		return nil, errors.New("approx: top-k is too large to be encoded")
+	}
+
+	cms, err := t.cms.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	var hll []byte // empty when the cardinality is not tracked
+	if t.hll != nil {
+		if hll, err = t.hll.MarshalBinary(); err != nil {
+			return nil, err
+		}
+	}
+
+	out := []byte{version}
+	out = binary.LittleEndian.AppendUint32(out, uint32(cap(t.heap)))
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(t.heap)))
+	out = binary.LittleEndian.AppendUint64(out, math.Float64bits(t.decay))
+	out = binary.LittleEndian.AppendUint64(out, t.seq)
+	for _, v := range t.heap {
+		out = binary.LittleEndian.AppendUint64(out, v.hash)
+		out = binary.LittleEndian.AppendUint64(out, v.seen)
+		out = binary.LittleEndian.AppendUint32(out, v.Count)
+		out = appendBytes(out, []byte(v.Value))
+	}
+
+	out = appendBytes(out, cms)
+	out = appendBytes(out, hll)
+	return out, nil
+}
+
+// UnmarshalBinary decodes the top-k elements, the Count-Min Sketch and the HyperLogLog
+// from their binary form, replacing the current state. Since the sketch is replaced
+// and updates read it without locking, like CountMin.UnmarshalBinary it must not be
+// called concurrently with other methods of the top-k.
+func (t *TopK) UnmarshalBinary(data []byte) error {
+	r := reader(data)
+	if r.byte() != version {
+		return errInvalidEncoding
+	}
+
+	k, n := r.uint32(), r.uint32()
+	decay, seq := math.Float64frombits(r.uint64()), r.uint64()
+	switch {
+	case r == nil || n > k || k > maxTopK || !(decay >= 0):
+		return errInvalidEncoding
+	case uint64(len(r)) < uint64(n)*topValueSize:
+		return errInvalidEncoding
+	}
+
+	heap := make(minheap, 0, k)
+	for i := uint32(0); i < n && r != nil; i++ {
+		hash, seen, count := r.uint64(), r.uint64(), r.uint32()
+		heap = append(heap, TopValue{
+			hash:  hash,
+			seen:  seen,
+			Count: count,
+			Value: string(r.bytes()),
+		})
+	}
+
+	cmsData, hllData := r.bytes(), r.bytes()
+	if r == nil || len(r) != 0 {
+		return errInvalidEncoding
+	}
+
+	cms := new(CountMin)
+	if err := cms.UnmarshalBinary(cmsData); err != nil {
+		return err
+	}
+
+	var hll *hyperloglog.Sketch
+	if len(hllData) > 0 {
+		hll = hyperloglog.New()
+		if err := hll.UnmarshalBinary(hllData); err != nil {
+			return err
+		}
+	}
+
+	t.mu.Lock()
+	t.cmu.Lock()
+	defer t.mu.Unlock()
+	defer t.cmu.Unlock()
+	t.heap = heap
+	t.cms = cms
+	clear(t.subs) // sub-values are not persisted
+	if t.index != nil {
+		clear(t.index)
+		for i, v := range heap {
+			t.index[v.hash] = i
+		}
+	}
+	t.hll = hll
+	t.exact = nil // exact set is not persisted
+	t.decay = decay
+	t.seq = seq
+	return nil
+}
+
+// GobEncode encodes the top-k for encoding/gob, using its binary form.
+func (t *TopK) GobEncode() ([]byte, error) {
+	return t.MarshalBinary()
+}
+
+// GobDecode decodes the top-k for encoding/gob, using its binary form.
+func (t *TopK) GobDecode(data []byte) error {
+	return t.UnmarshalBinary(data)
+}
+
+// ------------------------------------ Reader ------------------------------------
+
+// appendBytes appends a length-prefixed byte slice
+func appendBytes(dst, src []byte) []byte {
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(src)))
+	return append(dst, src...)
+}
+
+// reader is a simple binary reader, which becomes nil once it runs out of data
+type reader []byte
+
+// next returns the next n bytes of the reader
+func (r *reader) next(n uint32) []byte {
+	if uint64(n) > uint64(len(*r)) {
+		*r = nil
+		return make([]byte, 8)[:min(n, 8)]
+	}
+
+	out := (*r)[:n]
+	*r = (*r)[n:]
+	return out
+}
+
+func (r *reader) byte() byte     { return r.next(1)[0] }
+func (r *reader) uint32() uint32 { return binary.LittleEndian.Uint32(r.next(4)) }
+func (r *reader) uint64() uint64 { return binary.LittleEndian.Uint64(r.next(8)) }
+func (r *reader) bytes() []byte  { return r.next(r.uint32()) }
@@ -0,0 +1,229 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package approx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCount16x4_Codec(t *testing.T) {
+	var c Count16x4
+	for i := 0; i < 1000; i++ {
+		c.IncrementAt(i % 4)
+	}
+
+	encoded, err := c.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Len(t, encoded, 8)
+
+	var decoded Count16x4
+	assert.NoError(t, decoded.UnmarshalBinary(encoded))
+	assert.Equal(t, c.v.Load(), decoded.v.Load())
+	assert.Error(t, decoded.UnmarshalBinary(encoded[:7]))
+}
+
+func TestCountMin_Codec(t *testing.T) {
+	c, err := NewCountMinWithSize(4, 64)
+	assert.NoError(t, err)
+	c.AddString("foo", 100)
+	c.AddString("bar", 10)
+
+	encoded, err := c.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Len(t, encoded, 17+4*64*2)
+
+	decoded := new(CountMin)
+	assert.NoError(t, decoded.UnmarshalBinary(encoded))
+	assert.Equal(t, c.Depth(), decoded.Depth())
+	assert.Equal(t, c.Width(), decoded.Width())
+	assert.Equal(t, c.Total(), decoded.Total())
+	assert.Equal(t, c.CountString("foo"), decoded.CountString("foo"))
+	assert.Equal(t, c.CountString("bar"), decoded.CountString("bar"))
+
+	// Corrupted input must not decode
+	assert.Error(t, decoded.UnmarshalBinary(nil))
+	assert.Error(t, decoded.UnmarshalBinary(encoded[:len(encoded)-1]))
+	assert.Error(t, decoded.UnmarshalBinary([]byte{version, 0xff, 0xff, 0xff, 0xff, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}))
+}
+
+func TestCountMin_JSON(t *testing.T) {
+	c, err := NewCountMinWithSize(2, 8)
+	assert.NoError(t, err)
+	c.AddString("foo", 10)
+
+	encoded, err := json.Marshal(c)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"depth":2,"width":8,"total":10}`, string(encoded))
+
+	verbose, err := json.Marshal(c.Verbose())
+	assert.NoError(t, err)
+
+	var decoded struct {
+		Depth     int      `json:"depth"`
+		Total     uint     `json:"total"`
+		Estimates [][]uint `json:"estimates"`
+	}
+	assert.NoError(t, json.Unmarshal(verbose, &decoded))
+	assert.Equal(t, 2, decoded.Depth)
+	assert.Equal(t, uint(10), decoded.Total)
+	assert.Len(t, decoded.Estimates, 2)
+	for _, row := range decoded.Estimates {
+		assert.Len(t, row, 8)
+		assert.Equal(t, []uint{c.CountString("foo")}, nonzero(row))
+	}
+}
+
+// nonzero returns the non-zero values of the slice
+func nonzero(values []uint) (out []uint) {
+	for _, v := range values {
+		if v != 0 {
+			out = append(out, v)
+		}
+	}
+	return
+}
+
+func TestCountMin_CodecCleared(t *testing.T) {
+	c, err := NewCountMinWithSize(4, 64)
+	assert.NoError(t, err)
+	c.AddString("foo", 10)
+	c.ClearRow(0)
+	c.ClearRow(3)
+
+	encoded, err := c.MarshalBinary()
+	assert.NoError(t, err)
+
+	// Decoding replaces the cleared rows and the modes of the receiver
+	decoded, err := NewCountMinDeterministic(2, 8)
+	assert.NoError(t, err)
+	decoded.ClearRow(1)
+	assert.NoError(t, decoded.UnmarshalBinary(encoded))
+	assert.Equal(t, c.CountString("foo"), decoded.CountString("foo"))
+	assert.True(t, decoded.isCleared(0))
+	assert.False(t, decoded.isCleared(1))
+	assert.True(t, decoded.isCleared(3))
+	assert.False(t, decoded.pseudo)
+
+	// Without cleared rows, the masks are not encoded
+	c.Reset()
+	plain, err := c.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Len(t, plain, len(encoded)-16)
+	assert.Error(t, decoded.UnmarshalBinary(encoded[:30]))
+}
+
+func TestCountMin_CodecIndependent(t *testing.T) {
+	c, err := NewCountMinIndependent(4, 64)
+	assert.NoError(t, err)
+	c.AddString("foo", 100)
+
+	encoded, err := c.MarshalBinary()
+	assert.NoError(t, err)
+
+	decoded := new(CountMin)
+	assert.NoError(t, decoded.UnmarshalBinary(encoded))
+	assert.True(t, decoded.mixed)
+	assert.Equal(t, 4, decoded.Depth())
+	assert.Equal(t, c.CountString("foo"), decoded.CountString("foo"))
+}
+
+func TestTopK_Codec(t *testing.T) {
+	topk, err := NewTopK(5)
+	assert.NoError(t, err)
+
+	for _, v := range deck(20) {
+		topk.Update(v)
+	}
+
+	encoded, err := topk.MarshalBinary()
+	assert.NoError(t, err)
+
+	decoded, err := NewTopK(1)
+	assert.NoError(t, err)
+	assert.NoError(t, decoded.UnmarshalBinary(encoded))
+	assert.Equal(t, topk.Values(), decoded.Values())
+	assert.Equal(t, topk.Cardinality(), decoded.Cardinality())
+	assert.Equal(t, 5, cap(decoded.heap))
+
+	// The decoded structure should continue to be updated
+	for i := 0; i < 100; i++ {
+		decoded.Update("foo")
+	}
+	assert.True(t, decoded.Contains("foo"))
+	assert.Len(t, decoded.Values(), 5)
+
+	// Corrupted input must not decode
+	assert.Error(t, decoded.UnmarshalBinary(nil))
+	assert.Error(t, decoded.UnmarshalBinary(encoded[:len(encoded)-1]))
+	assert.Error(t, decoded.UnmarshalBinary(append(encoded, 0)))
+}
+
+func TestTopK_CodecHugeK(t *testing.T) {
+	decoded, err := NewTopK(1)
+	assert.NoError(t, err)
+
+	// A short payload claiming a huge k must not allocate the heap
+	data := []byte{version}
+	data = binary.LittleEndian.AppendUint32(data, math.MaxUint32)
+	data = binary.LittleEndian.AppendUint32(data, 0)
+	data = binary.LittleEndian.AppendUint64(data, 0)
+	data = binary.LittleEndian.AppendUint64(data, 0)
+	assert.Error(t, decoded.UnmarshalBinary(data))
+
+	// Nor a payload claiming more elements than it holds
+	data[1], data[2], data[3], data[4] = 0, 0, 0, 1
+	data[5], data[6], data[7], data[8] = 0, 0, 0, 1
+	assert.Error(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, 1, cap(decoded.heap))
+}
+
+func TestTopK_CodecNoCardinality(t *testing.T) {
+	topk, err := NewTopKNoCardinality(5)
+	assert.NoError(t, err)
+	for _, v := range deck(20) {
+		topk.Update(v)
+	}
+
+	encoded, err := topk.MarshalBinary()
+	assert.NoError(t, err)
+
+	decoded, err := NewTopK(1)
+	assert.NoError(t, err)
+	assert.NoError(t, decoded.UnmarshalBinary(encoded))
+	assert.Equal(t, topk.Values(), decoded.Values())
+	assert.Nil(t, decoded.hll)
+	assert.Zero(t, decoded.Cardinality())
+}
+
+func TestCodec_Gob(t *testing.T) {
+	cms, _ := NewCountMin()
+	cms.AddString("foo", 100)
+	topk, _ := NewTopK(5)
+	for _, v := range deck(10) {
+		topk.Update(v)
+	}
+
+	var buffer bytes.Buffer
+	assert.NoError(t, gob.NewEncoder(&buffer).Encode(struct {
+		CMS  *CountMin
+		TopK *TopK
+	}{cms, topk}))
+
+	var decoded struct {
+		CMS  *CountMin
+		TopK *TopK
+	}
+	assert.NoError(t, gob.NewDecoder(&buffer).Decode(&decoded))
+	assert.Equal(t, cms.CountString("foo"), decoded.CMS.CountString("foo"))
+	assert.Equal(t, cms.Total(), decoded.CMS.Total())
+	assert.Equal(t, topk.Values(), decoded.TopK.Values())
+	assert.Equal(t, topk.Cardinality(), decoded.TopK.Cardinality())
+}
@@ -4,17 +4,98 @@
 package approx
 
 import (
+	"errors"
 	"math"
+	"sync"
 	"sync/atomic"
 
 	_ "unsafe" // For go:linkname
 )
 
+// Maximum counts each of the counters can represent, once saturated.
+const (
+	MaxCount4  = 3188         // Maximum count of Count4
+	MaxCount8  = 101681       // Maximum count of Count8
+	MaxCount16 = 1383175818   // Maximum count of Count16
+	MaxCount24 = 108040074363 // Maximum count of Count24
+)
+
+// Counter represents an approximate counter.
+type Counter interface {
+	Estimate() uint  // Estimate returns the estimated count
+	Increment() uint // Increment increments the counter and returns the estimate
+	MaxCount() uint  // MaxCount returns the maximum count the counter can represent
+}
+
+// NewCounterForError returns the smallest counter which can count up to maxExpected with
+// a mean relative error (e.g. 0.01 for 1%) no larger than maxMeanError. It returns an
+// error if none of the counters satisfies both requirements.
+func NewCounterForError(maxExpected uint, maxMeanError float64) (Counter, error) {
+	for _, c := range []struct {
+		maxCount  uint
+		meanError float64
+		counter   func() Counter
+	}{
+		{MaxCount4, 0.50, func() Counter { return new(Count4) }},
+		{MaxCount8, 0.10, func() Counter { return new(Count8) }},
+		{MaxCount16, 0.005, func() Counter { return new(Count16) }},
+	} {
+		if maxExpected <= c.maxCount && maxMeanError >= c.meanError {
+			return c.counter(), nil
+		}
+	}
+
+	return nil, errors.New("approx: no counter satisfies the expected range and error")
+}
+
 // n computes the approximate count based on Morris's algorithm
 func n(v, a float64) float64 {
 	return a * (math.Pow(1+1/a, v) - 1)
 }
 
+// deviation computes the standard deviation of the estimate after x observations, using
+// the variance of Morris's algorithm with base 1+1/a, which is x*(x-1)/(2a).
+func deviation(x, a float64) float64 {
+	return math.Sqrt(max(x*(x-1), 0) / (2 * a))
+}
+
+// advance16 returns the 16-bit counter state after n observations. The state is chosen
+// so that the expected estimate equals the current estimate plus n, rounding to one of
+// the two neighbouring states with the given roll.
+func advance16(state uint16, delta uint, roll float32) uint16 {
+	value := n(float64(state), scale16) + float64(delta)
+	next := math.Floor(math.Log1p(value/scale16) / math.Log1p(1.0/scale16))
+	if next >= math.MaxUint16 {
+		return math.MaxUint16
+	}
+
+	// Round up with a probability proportional to the distance between the states
+	lo, hi := n(next, scale16), n(next+1, scale16)
+	if float64(roll) < (value-lo)/(hi-lo) {
+		next++
+	}
+
+	return max(state, uint16(next))
+}
+
+// encode16 returns the 16-bit counter state whose estimate is nearest to the value.
+func encode16(value float64) uint16 {
+	if !(value > 0) {
+		return 0
+	}
+
+	state := math.Floor(math.Log1p(value/scale16) / math.Log1p(1.0/scale16))
+	if state >= math.MaxUint16 {
+		return math.MaxUint16
+	}
+
+	// Pick the closest of the two neighbouring states
+	if n(state+1, scale16)-value < value-n(state, scale16) {
+		state++
+	}
+	return uint16(state)
+}
+
 //go:linkname runtime_rand runtime.rand
 func runtime_rand() uint64
 
@@ -30,6 +111,81 @@ func roll32() float32 {
 	return float32(uint32(runtime_rand())<<8>>8) / (1 << 24)
 }
 
+// Rand returns a fast, thread-local random float32 in the range [0, 1). It is the same
+// source of randomness used by the counters to decide whether to increment.
+func Rand() float32 {
+	return roll32()
+}
+
+// ------------------------------------ Count4 ------------------------------------
+
+const (
+	scale4 = 1.5 // scale factor
+	upper4 = 16  // upper bound
+)
+
+// Precompute the lookup table for the 4-bit counter
+var n4 [upper4]uint = func() [upper4]uint {
+	var lookup [upper4]uint
+	for i := range lookup {
+		lookup[i] = uint(n(float64(i), scale4))
+	}
+	lookup[1] = 1 // special case for c=1
+	return lookup
+}()
+
+// Precompute the delta table for the 4-bit counter
+var d4 [upper4]float32 = func() [upper4]float32 {
+	var lookup [upper4]float32
+	for i := 0; i < len(lookup)-1; i++ {
+		lookup[i] = float32(1 / (n(float64(i+1), scale4) - n(float64(i), scale4)))
+	}
+	lookup[upper4-1] = 0 // no chance to increment
+	return lookup
+}()
+
+// Count4 is a 4-bit counter that uses Morris's algorithm to estimate the count. Only
+// the lower nibble is used, giving 16 states that count up to ~3k with a rough error
+// rate. It is mostly useful as the building block for Count4x16.
+type Count4 uint8
+
+// Estimate returns the estimated count
+func (c Count4) Estimate() uint {
+	return n4[c&0xF]
+}
+
+// EstimateFloat returns the estimated count without truncating it to an integer, which
+// avoids accumulating the truncation error when summing many counters.
+func (c Count4) EstimateFloat() float64 {
+	return n(float64(c&0xF), scale4)
+}
+
+// IncrementProbability returns the probability that the next Increment advances the
+// counter. It trends towards zero as the counter fills up and is zero once saturated.
+func (c Count4) IncrementProbability() float32 {
+	return d4[c&0xF]
+}
+
+// MaxCount returns the maximum count the counter can represent
+func (c Count4) MaxCount() uint {
+	return MaxCount4
+}
+
+// IsSaturated returns whether the counter has reached its maximum state. Once saturated,
+// the counter no longer increments and the estimate should be treated as a lower bound.
+func (c Count4) IsSaturated() bool {
+	return c&0xF == upper4-1
+}
+
+// Increment increments the counter
+func (c *Count4) Increment() uint {
+	*c &= 0xF
+	if roll32() < d4[*c] {
+		(*c)++
+	}
+	return n4[*c]
+}
+
 // ------------------------------------ Count8 ------------------------------------
 
 const (
@@ -57,6 +213,12 @@ var d8 [upper8]float32 = func() [upper8]float32 {
 	return lookup
 }()
 
+// Count8Deltas returns a copy of the increment probabilities of the 8-bit counter,
+// indexed by counter state. Modifying the copy does not affect the counter.
+func Count8Deltas() [upper8]float32 {
+	return d8
+}
+
 // Count8 is a 8-bit counter that uses Morris's algorithm to estimate the count. The
 // counter was tuned to count up to ~100k with relatively mean error rate of
 // around ~10%.
@@ -67,6 +229,35 @@ func (c Count8) Estimate() uint {
 	return n8[c]
 }
 
+// EstimateFloat returns the estimated count without truncating it to an integer, which
+// avoids accumulating the truncation error when summing many counters.
+func (c Count8) EstimateFloat() float64 {
+	return n(float64(c), scale8)
+}
+
+// EstimateWithStdDev returns the estimated count along with its standard deviation,
+// which grows roughly linearly with the count.
+func (c Count8) EstimateWithStdDev() (estimate uint, stddev float64) {
+	return c.Estimate(), deviation(c.EstimateFloat(), scale8)
+}
+
+// IncrementProbability returns the probability that the next Increment advances the
+// counter. It trends towards zero as the counter fills up and is zero once saturated.
+func (c Count8) IncrementProbability() float32 {
+	return d8[c]
+}
+
+// MaxCount returns the maximum count the counter can represent
+func (c Count8) MaxCount() uint {
+	return MaxCount8
+}
+
+// IsSaturated returns whether the counter has reached its maximum state. Once saturated,
+// the counter no longer increments and the estimate should be treated as a lower bound.
+func (c Count8) IsSaturated() bool {
+	return c == math.MaxUint8
+}
+
 // Increment increments the counter
 func (c *Count8) Increment() uint {
 	if roll32() < d8[*c] {
@@ -75,6 +266,13 @@ func (c *Count8) Increment() uint {
 	return n8[*c]
 }
 
+// IncrementRaw increments the counter and returns both the estimate and the raw state
+// of the counter after the increment, which is useful for debugging the transitions.
+func (c *Count8) IncrementRaw() (estimate uint, raw uint8) {
+	estimate = c.Increment()
+	return estimate, uint8(*c)
+}
+
 // ------------------------------------ Count16 ------------------------------------
 
 const (
@@ -82,24 +280,50 @@ const (
 	upper16 = math.MaxUint16 + 1 // upper bound
 )
 
-// Precompute the lookup table for the 16-bit counter
-var n16 [upper16]uint = func() [upper16]uint {
-	var lookup [upper16]uint
-	for i := range lookup {
-		lookup[i] = uint(n(float64(i), scale16))
-	}
-	lookup[1] = 1 // special case for c=1
-	return lookup
-}()
+// table16 holds the lookup and delta tables of the 16-bit counter. Since they are large
+// and expensive to compute (~13ms and 1.5MB at package init), they are only computed on
+// first use, see lookup16. Programs that never touch a 16-bit counter do not pay for them.
+type table16 struct {
+	n [upper16]uint    // lookup table of the estimates
+	d [upper16]float32 // delta table of the increment probabilities
+}
 
-// Precompute the delta table for the 16-bit counter
-var d16 [upper16]float32 = func() [upper16]float32 {
-	var lookup [upper16]float32
-	for i := 0; i < len(lookup)-1; i++ {
-		lookup[i] = float32(1 / (n(float64(i+1), scale16) - n(float64(i), scale16)))
+var (
+	once16 sync.Once
+	tables atomic.Pointer[table16]
+)
+
+// lookup16 returns the tables of the 16-bit counter, computing them on first use. The
+// fast path is a single atomic load, so it can be called on every access.
+func lookup16() *table16 {
+	if t := tables.Load(); t != nil {
+		return t
 	}
-	return lookup
-}()
+	return build16()
+}
+
+// build16 computes the tables of the 16-bit counter, once.
+func build16() *table16 {
+	once16.Do(func() {
+		t := new(table16)
+		for i := range t.n {
+			t.n[i] = uint(n(float64(i), scale16))
+		}
+		t.n[1] = 1 // special case for c=1
+
+		for i := 0; i < len(t.d)-1; i++ {
+			t.d[i] = float32(1 / (n(float64(i+1), scale16) - n(float64(i), scale16)))
+		}
+		tables.Store(t)
+	})
+	return tables.Load()
+}
+
+// Count16Deltas returns a copy of the increment probabilities of the 16-bit counter,
+// indexed by counter state. Modifying the copy does not affect the counter.
+func Count16Deltas() []float32 {
+	return append([]float32(nil), lookup16().d[:]...)
+}
 
 // Count16 is a 16-bit counter that uses Morris's algorithm to estimate the count. The
 // counter was tuned to count up to ~2 billion with relatively low mean error rate of
@@ -108,15 +332,213 @@ type Count16 uint16
 
 // Estimate returns the estimated count
 func (c Count16) Estimate() uint {
-	return n16[c]
+	return lookup16().n[c]
+}
+
+// EstimateFloat returns the estimated count without truncating it to an integer, which
+// avoids accumulating the truncation error when summing many counters.
+func (c Count16) EstimateFloat() float64 {
+	return n(float64(c), scale16)
+}
+
+// EstimateWithStdDev returns the estimated count along with its standard deviation,
+// which grows roughly linearly with the count.
+func (c Count16) EstimateWithStdDev() (estimate uint, stddev float64) {
+	return c.Estimate(), deviation(c.EstimateFloat(), scale16)
+}
+
+// IncrementProbability returns the probability that the next Increment advances the
+// counter. It trends towards zero as the counter fills up and is zero once saturated.
+func (c Count16) IncrementProbability() float32 {
+	return lookup16().d[c]
+}
+
+// MaxCount returns the maximum count the counter can represent
+func (c Count16) MaxCount() uint {
+	return MaxCount16
+}
+
+// IsSaturated returns whether the counter has reached its maximum state. Once saturated,
+// the counter no longer increments and the estimate should be treated as a lower bound.
+func (c Count16) IsSaturated() bool {
+	return c == math.MaxUint16
 }
 
 // Increment increments the counter
 func (c *Count16) Increment() uint {
-	if roll32() < d16[*c] {
+	t := lookup16()
+	if *c < math.MaxUint16 && roll32() < t.d[*c] {
 		(*c)++
 	}
-	return n16[*c]
+	return t.n[*c]
+}
+
+// IncrementRaw increments the counter and returns both the estimate and the raw state
+// of the counter after the increment, which is useful for debugging the transitions.
+func (c *Count16) IncrementRaw() (estimate uint, raw uint16) {
+	estimate = c.Increment()
+	return estimate, uint16(*c)
+}
+
+// MergeCount16 combines two 16-bit counters by summing their estimates and re-encoding
+// the sum to the nearest counter state. The rounding to the nearest state is not
+// unbiased: the merged estimate can be off by up to half the distance between two
+// neighbouring states, which is roughly sum/(2*5250) or ~0.01% of the sum, and merging
+// repeatedly compounds this error on top of the error of the counters themselves.
+func MergeCount16(a, b Count16) Count16 {
+	return Count16(encode16(a.EstimateFloat() + b.EstimateFloat()))
+}
+
+// ByEstimate implements sort.Interface for a slice of Count16, ordering the counters
+// by their estimated count from lowest to highest.
+type ByEstimate []Count16
+
+func (b ByEstimate) Len() int           { return len(b) }
+func (b ByEstimate) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b ByEstimate) Less(i, j int) bool { return b[i].Estimate() < b[j].Estimate() }
+
+// ------------------------------------ Count24 ------------------------------------
+
+const (
+	scale24 = 1500000     // scale factor
+	upper24 = 1 << 24     // upper bound
+	mask24  = upper24 - 1 // mask of the used bits
+)
+
+// log24 is the logarithm of the base of the 24-bit counter
+var log24 = math.Log1p(1.0 / scale24)
+
+// Count24 is a 24-bit counter, stored in the lower 24 bits of a uint32, that uses Morris's
+// algorithm to estimate the count. The counter was tuned to count up to ~10^11 with a mean
+// error rate well below 0.1%. Since a table of 2^24 states would be too large, estimates
+// are computed on the fly. As the maximum count does not fit into 32 bits, estimates are
+// returned as uint64 and the counter does not implement Counter.
+type Count24 uint32
+
+// Estimate returns the estimated count
+func (c Count24) Estimate() uint64 {
+	return uint64(c.EstimateFloat())
+}
+
+// EstimateFloat returns the estimated count without truncating it to an integer, which
+// avoids accumulating the truncation error when summing many counters.
+func (c Count24) EstimateFloat() float64 {
+	return scale24 * math.Expm1(float64(c&mask24)*log24)
+}
+
+// IncrementProbability returns the probability that the next Increment advances the
+// counter. It trends towards zero as the counter fills up and is zero once saturated.
+func (c Count24) IncrementProbability() float32 {
+	if c.IsSaturated() {
+		return 0
+	}
+
+	// The distance between two states v and v+1 is (1+1/a)^v
+	return float32(math.Exp(-float64(c&mask24) * log24))
+}
+
+// MaxCount returns the maximum count the counter can represent
+func (c Count24) MaxCount() uint64 {
+	return MaxCount24
+}
+
+// IsSaturated returns whether the counter has reached its maximum state. Once saturated,
+// the counter no longer increments and the estimate should be treated as a lower bound.
+func (c Count24) IsSaturated() bool {
+	return c&mask24 == mask24
+}
+
+// Increment increments the counter
+func (c *Count24) Increment() uint64 {
+	*c &= mask24
+	if roll32() < c.IncrementProbability() {
+		(*c)++
+	}
+	return c.Estimate()
+}
+
+// ------------------------------------ AutoCount ------------------------------------
+
+// AutoCount is an approximate counter which starts as a Count8 and, once it saturates,
+// transparently promotes itself to a Count16 seeded with the nearest state for the
+// current estimate. This avoids having to guess the magnitude of the stream upfront,
+// at the cost of the larger error of the 8-bit counter for the first ~100k counts.
+type AutoCount struct {
+	small Count8  // counter used before the promotion
+	large Count16 // counter used after the promotion
+	wide  bool    // whether the counter was promoted
+}
+
+// Estimate returns the estimated count
+func (c *AutoCount) Estimate() uint {
+	if c.wide {
+		return c.large.Estimate()
+	}
+	return c.small.Estimate()
+}
+
+// MaxCount returns the maximum count the counter can represent
+func (c *AutoCount) MaxCount() uint {
+	return MaxCount16
+}
+
+// IsPromoted returns whether the counter was promoted to 16 bits
+func (c *AutoCount) IsPromoted() bool {
+	return c.wide
+}
+
+// Increment increments the counter and returns the estimate
+func (c *AutoCount) Increment() uint {
+	if c.wide {
+		return c.large.Increment()
+	}
+
+	estimate := c.small.Increment()
+	if c.small.IsSaturated() {
+		c.large = Count16(encode16(c.small.EstimateFloat()))
+		c.wide = true
+		return c.large.Estimate()
+	}
+	return estimate
+}
+
+// ------------------------------------ HybridCount ------------------------------------
+
+// HybridCutoff is the count below which a HybridCount is exact
+const HybridCutoff = 256
+
+// HybridCount is a 16-bit counter which counts exactly up to HybridCutoff, then continues
+// as an approximate counter with the semantics of Count16, offset by the cutoff. This
+// gives exact counts for the long tail of rare items while keeping the counter small.
+type HybridCount uint16
+
+// Estimate returns the estimated count, which is exact below HybridCutoff
+func (c HybridCount) Estimate() uint {
+	if c < HybridCutoff {
+		return uint(c)
+	}
+	return HybridCutoff + lookup16().n[c-HybridCutoff]
+}
+
+// MaxCount returns the maximum count the counter can represent
+func (c HybridCount) MaxCount() uint {
+	return HybridCutoff + lookup16().n[math.MaxUint16-HybridCutoff]
+}
+
+// IsExact returns whether the counter is still below the cutoff and hence exact
+func (c HybridCount) IsExact() bool {
+	return c < HybridCutoff
+}
+
+// Increment increments the counter and returns the estimate
+func (c *HybridCount) Increment() uint {
+	switch {
+	case *c < HybridCutoff:
+		(*c)++
+	case *c < math.MaxUint16 && roll32() < lookup16().d[*c-HybridCutoff]:
+		(*c)++
+	}
+	return c.Estimate()
 }
 
 // ------------------------------------ Count16x4 ------------------------------------
@@ -129,11 +551,12 @@ type Count16x4 struct {
 
 // estimate16x4 returns the estimated count for all counters.
 func estimate16x4(v uint64) [4]uint {
+	t := lookup16()
 	return [4]uint{
-		n16[uint16(v&0xFFFF)],
-		n16[uint16((v>>16)&0xFFFF)],
-		n16[uint16((v>>32)&0xFFFF)],
-		n16[uint16((v>>48)&0xFFFF)],
+		t.n[uint16(v&0xFFFF)],
+		t.n[uint16((v>>16)&0xFFFF)],
+		t.n[uint16((v>>32)&0xFFFF)],
+		t.n[uint16((v>>48)&0xFFFF)],
 	}
 }
 
@@ -142,13 +565,31 @@ func (c *Count16x4) Estimate() [4]uint {
 	return estimate16x4(c.v.Load())
 }
 
+// Sum returns the sum of the estimated counts of all counters, from a single load.
+func (c *Count16x4) Sum() uint {
+	v := c.v.Load()
+	t := lookup16()
+	return t.n[uint16(v)] + t.n[uint16(v>>16)] + t.n[uint16(v>>32)] + t.n[uint16(v>>48)]
+}
+
 // EstimateAt returns the estimated count for the counter at the given index.
 func (c *Count16x4) EstimateAt(i int) uint {
 	if i < 0 || i > 3 {
 		return 0
 	}
 
-	return c.Estimate()[i]
+	return lookup16().n[uint16(c.v.Load()>>(i*16))]
+}
+
+// IsSaturatedAt returns whether the counter at the given index has reached its maximum
+// state. Once saturated, the counter no longer increments and the estimate should be
+// treated as a lower bound.
+func (c *Count16x4) IsSaturatedAt(i int) bool {
+	if i < 0 || i > 3 {
+		return false
+	}
+
+	return c.state(i) == math.MaxUint16
 }
 
 // IncrementAt increments the counter at the given index. It returns true if the counter
@@ -158,19 +599,40 @@ func (c *Count16x4) IncrementAt(i int) bool {
 		return false
 	}
 
-	return c.incrementAt(i, roll32())
+	return c.incrementAt(lookup16(), i, roll32())
+}
+
+// IncrementAtForced advances the counter at the given index by one state, bypassing the
+// random roll, and returns the estimated count afterwards. This is useful when the caller
+// has already made the sampling decision. A saturated counter is left unchanged.
+func (c *Count16x4) IncrementAtForced(i int) uint {
+	if i < 0 || i > 3 {
+		return 0
+	}
+
+	t := lookup16()
+	c.incrementAt(t, i, -1) // a negative roll always succeeds
+	return t.n[c.state(i)]
+}
+
+// state returns the raw state of the counter at the given index.
+func (c *Count16x4) state(i int) uint16 {
+	return uint16(c.v.Load() >> (i * 16))
 }
 
-// IncrementAt increments the counter at the given index with a given probability of success.
-func (c *Count16x4) incrementAt(i int, roll float32) bool {
+// IncrementAt increments the counter at the given index with a given probability of success,
+// using the provided tables of the 16-bit counter.
+func (c *Count16x4) incrementAt(t *table16, i int, roll float32) bool {
 	shft := uint(i * 16) // number of bits to shift
 	for {
 		loaded := c.v.Load()
 
 		// Inlined version of Count16.Increment. Early return allows us to avoid the
-		// cost of the atomic operation if we don't need to increment the counter.
+		// cost of the atomic operation if we don't need to increment the counter. The
+		// delta of the last state is zero, but we also guard explicitly against a wrap
+		// of a saturated lane back to zero.
 		counter := uint16(loaded >> shft)
-		if roll >= d16[counter] {
+		if counter == math.MaxUint16 || roll >= t.d[counter] {
 			return false
 		}
 
@@ -185,7 +647,314 @@ func (c *Count16x4) incrementAt(i int, roll float32) bool {
 	}
 }
 
+// IncrementAtN increments the counter at the given index by n observations with a single
+// compare-and-swap loop, and returns the estimated count of the counter afterwards. This
+// is equivalent to calling IncrementAt n times, but much cheaper for pre-aggregated data.
+func (c *Count16x4) IncrementAtN(i int, n uint) uint {
+	if i < 0 || i > 3 {
+		return 0
+	}
+
+	c.incrementAtN(i, n, roll32())
+	return c.EstimateAt(i)
+}
+
+// incrementAtN increments the counter at the given index by n observations. It returns
+// true if the counter estimate was updated.
+func (c *Count16x4) incrementAtN(i int, n uint, roll float32) bool {
+	shft := uint(i * 16) // number of bits to shift
+	for {
+		loaded := c.v.Load()
+		counter := uint16(loaded >> shft)
+		next := advance16(counter, n, roll)
+		if next == counter {
+			return false
+		}
+
+		// Pack the new state and try to swap the value atomically
+		updated := (uint64(next) << shft) | (loaded & ^(0xFFFF << shft))
+		if c.v.CompareAndSwap(loaded, updated) {
+			return true
+		}
+	}
+}
+
+// Load atomically loads the raw packed word along with the estimated count for all of
+// the counters. The state of the counter at index i is stored in bits [16*i, 16*i+16).
+func (c *Count16x4) Load() (raw uint64, estimate [4]uint) {
+	raw = c.v.Load()
+	return raw, estimate16x4(raw)
+}
+
+// StoreRaw atomically stores the raw packed word, replacing the state of all counters.
+func (c *Count16x4) StoreRaw(raw uint64) {
+	c.v.Store(raw)
+}
+
+// Equal returns whether both packed counters hold the same state, comparing the
+// atomically loaded words.
+func (c *Count16x4) Equal(other *Count16x4) bool {
+	return c.v.Load() == other.v.Load()
+}
+
+// Set atomically copies the state of the other packed counter into this one.
+func (c *Count16x4) Set(other *Count16x4) {
+	c.v.Store(other.v.Load())
+}
+
+// CompareAndSwapRaw executes the compare-and-swap operation on the raw packed word,
+// which allows building custom atomic update logic on top of the counters.
+func (c *Count16x4) CompareAndSwapRaw(old, new uint64) bool {
+	return c.v.CompareAndSwap(old, new)
+}
+
+// transform atomically replaces the state of every lane with the result of the function.
+func (c *Count16x4) transform(fn func(lane int, state uint16) uint16) {
+	for {
+		loaded := c.v.Load()
+		updated := uint64(0)
+		for i := 0; i < 4; i++ {
+			shft := uint(i * 16)
+			updated |= uint64(fn(i, uint16(loaded>>shft))) << shft
+		}
+
+		if loaded == updated || c.v.CompareAndSwap(loaded, updated) {
+			return
+		}
+	}
+}
+
+// ResetAt resets the counter at the given index to zero, leaving the other counters
+// intact. It returns the estimated count of the counter prior to the reset.
+func (c *Count16x4) ResetAt(i int) uint {
+	if i < 0 || i > 3 {
+		return 0
+	}
+
+	shft := uint(i * 16) // number of bits to shift
+	for {
+		loaded := c.v.Load()
+		if c.v.CompareAndSwap(loaded, loaded & ^(0xFFFF<<shft)) {
+			return lookup16().n[uint16(loaded>>shft)]
+		}
+	}
+}
+
 // Reset resets the counter to zero. It returns the estimated count for all counters.
 func (c *Count16x4) Reset() [4]uint {
 	return estimate16x4((*c).v.Swap(0))
 }
+
+// ------------------------------------ Count16x4Peak ------------------------------------
+
+// Count16x4Peak represents 4 16-bit approximate counters, along with the peak estimate
+// each of them has reached, which is kept when the counters are reset. This is useful
+// for high water mark monitoring, at the cost of a second word.
+type Count16x4Peak struct {
+	live Count16x4 // current counters
+	peak Count16x4 // highest state reached by each of the counters
+}
+
+// Estimate returns the estimated count for all counters.
+func (c *Count16x4Peak) Estimate() [4]uint {
+	return c.live.Estimate()
+}
+
+// EstimateAt returns the estimated count for the counter at the given index.
+func (c *Count16x4Peak) EstimateAt(i int) uint {
+	return c.live.EstimateAt(i)
+}
+
+// Peak returns the highest estimated count reached by each of the counters.
+func (c *Count16x4Peak) Peak() [4]uint {
+	return c.peak.Estimate()
+}
+
+// PeakAt returns the highest estimated count reached by the counter at the given index.
+func (c *Count16x4Peak) PeakAt(i int) uint {
+	return c.peak.EstimateAt(i)
+}
+
+// IncrementAt increments the counter at the given index and updates its peak. It returns
+// true if the counter estimate was updated.
+func (c *Count16x4Peak) IncrementAt(i int) bool {
+	if !c.live.IncrementAt(i) {
+		return false
+	}
+
+	c.observe(i)
+	return true
+}
+
+// IncrementAtN increments the counter at the given index by n observations and updates
+// its peak. It returns the estimated count of the counter afterwards.
+func (c *Count16x4Peak) IncrementAtN(i int, n uint) uint {
+	estimate := c.live.IncrementAtN(i, n)
+	if estimate > 0 {
+		c.observe(i)
+	}
+	return estimate
+}
+
+// observe raises the peak of the counter at the given index to its current state.
+func (c *Count16x4Peak) observe(i int) {
+	state := c.live.state(i)
+	c.peak.transform(func(lane int, peak uint16) uint16 {
+		if lane == i {
+			return max(peak, state)
+		}
+		return peak
+	})
+}
+
+// ResetAt resets the counter at the given index to zero, keeping its peak. It returns
+// the estimated count of the counter prior to the reset.
+func (c *Count16x4Peak) ResetAt(i int) uint {
+	return c.live.ResetAt(i)
+}
+
+// Reset resets the counters to zero, keeping their peaks. It returns the estimated count
+// for all counters.
+func (c *Count16x4Peak) Reset() [4]uint {
+	return c.live.Reset()
+}
+
+// ResetPeak resets the peaks to zero. It returns the peak estimates for all counters.
+func (c *Count16x4Peak) ResetPeak() [4]uint {
+	return c.peak.Reset()
+}
+
+// ------------------------------------ Count8x8 ------------------------------------
+
+// Count8x8 represents 8 8-bit approximate counters packed in a single word, using
+// atomic operations to increment the counter.
+type Count8x8 struct {
+	v atomic.Uint64
+}
+
+// estimate8x8 returns the estimated count for all counters.
+func estimate8x8(v uint64) (out [8]uint) {
+	for i := range out {
+		out[i] = n8[uint8(v>>(i*8))]
+	}
+	return
+}
+
+// Estimate returns the estimated count for all counters.
+func (c *Count8x8) Estimate() [8]uint {
+	return estimate8x8(c.v.Load())
+}
+
+// EstimateAt returns the estimated count for the counter at the given index.
+func (c *Count8x8) EstimateAt(i int) uint {
+	if i < 0 || i > 7 {
+		return 0
+	}
+
+	return n8[uint8(c.v.Load()>>(i*8))]
+}
+
+// IncrementAt increments the counter at the given index. It returns true if the counter
+// estimate was updated.
+func (c *Count8x8) IncrementAt(i int) bool {
+	if i < 0 || i > 7 {
+		return false
+	}
+
+	return c.incrementAt(i, roll32())
+}
+
+// incrementAt increments the counter at the given index with a given probability of success.
+func (c *Count8x8) incrementAt(i int, roll float32) bool {
+	shft := uint(i * 8) // number of bits to shift
+	for {
+		loaded := c.v.Load()
+		counter := uint8(loaded >> shft)
+		if counter == math.MaxUint8 || roll >= d8[counter] {
+			return false
+		}
+
+		// Increment the counter and pack it back
+		updated := (uint64(counter+1) << shft) | (loaded & ^(0xFF << shft))
+		if c.v.CompareAndSwap(loaded, updated) {
+			return true
+		}
+	}
+}
+
+// Reset resets the counter to zero. It returns the estimated count for all counters.
+func (c *Count8x8) Reset() [8]uint {
+	return estimate8x8(c.v.Swap(0))
+}
+
+// ------------------------------------ Count4x16 ------------------------------------
+
+// Count4x16 represents 16 4-bit approximate counters packed in a single word, using
+// atomic operations to increment the counter.
+type Count4x16 struct {
+	v atomic.Uint64
+}
+
+// estimate4x16 returns the estimated count for all counters.
+func estimate4x16(v uint64) (out [16]uint) {
+	for i := range out {
+		out[i] = n4[(v>>(i*4))&0xF]
+	}
+	return
+}
+
+// Estimate returns the estimated count for all counters.
+func (c *Count4x16) Estimate() [16]uint {
+	return estimate4x16(c.v.Load())
+}
+
+// Sum returns the sum of the estimated counts of all counters, from a single load.
+func (c *Count4x16) Sum() (sum uint) {
+	for v := c.v.Load(); v != 0; v >>= 4 {
+		sum += n4[v&0xF]
+	}
+	return
+}
+
+// EstimateAt returns the estimated count for the counter at the given index.
+func (c *Count4x16) EstimateAt(i int) uint {
+	if i < 0 || i > 15 {
+		return 0
+	}
+
+	return n4[(c.v.Load()>>(i*4))&0xF]
+}
+
+// IncrementAt increments the counter at the given index. It returns true if the counter
+// estimate was updated.
+func (c *Count4x16) IncrementAt(i int) bool {
+	if i < 0 || i > 15 {
+		return false
+	}
+
+	return c.incrementAt(i, roll32())
+}
+
+// incrementAt increments the counter at the given index with a given probability of success.
+func (c *Count4x16) incrementAt(i int, roll float32) bool {
+	shft := uint(i * 4) // number of bits to shift
+	for {
+		loaded := c.v.Load()
+		counter := (loaded >> shft) & 0xF
+		if roll >= d4[counter] {
+			return false
+		}
+
+		// Increment the counter and pack it back
+		counter++
+		updated := (counter << shft) | (loaded & ^(0xF << shft))
+		if c.v.CompareAndSwap(loaded, updated) {
+			return true
+		}
+	}
+}
+
+// Reset resets the counter to zero. It returns the estimated count for all counters.
+func (c *Count4x16) Reset() [16]uint {
+	return estimate4x16(c.v.Swap(0))
+}
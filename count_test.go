@@ -5,6 +5,7 @@ package approx
 
 import (
 	"math"
+	"sort"
 	"testing"
 	"unsafe"
 
@@ -35,6 +36,13 @@ func BenchmarkCount(b *testing.B) {
 		}
 	})
 
+	b.Run("c24", func(b *testing.B) {
+		var c Count24
+		for i := 0; i < b.N; i++ {
+			c.Increment()
+		}
+	})
+
 	b.Run("c16x4", func(b *testing.B) {
 		var c Count16x4
 		for i := 0; i < b.N; i++ {
@@ -43,6 +51,23 @@ func BenchmarkCount(b *testing.B) {
 	})
 }
 
+func TestCount4_MeanEstimate(t *testing.T) {
+	const upper, counters = 1000, 200
+
+	// With only 16 states a single counter is very noisy, but it should remain
+	// unbiased so the mean across many counters should be close to the truth.
+	var sum uint
+	for n := 0; n < counters; n++ {
+		var c Count4
+		for i := 0; i < upper; i++ {
+			c.Increment()
+		}
+		sum += c.Estimate()
+	}
+
+	assert.InDelta(t, upper, sum/counters, upper*0.25)
+}
+
 func TestCount8_MeanError(t *testing.T) {
 	const upper = 1e4
 	var c Count8
@@ -96,6 +121,121 @@ func TestCount8_Overflow(t *testing.T) {
 	})
 }
 
+func TestCount_IsSaturated(t *testing.T) {
+	var c8 Count8
+	for i := 0; i < 1e6 && !c8.IsSaturated(); i++ {
+		c8.Increment()
+	}
+
+	assert.True(t, c8.IsSaturated())
+	assert.Equal(t, Count8(math.MaxUint8), c8)
+	assert.Equal(t, c8.Estimate(), c8.Increment())
+
+	var c4 Count4
+	assert.False(t, c4.IsSaturated())
+	for i := 0; i < 1e5; i++ {
+		c4.Increment()
+	}
+	assert.True(t, c4.IsSaturated())
+
+	c16 := Count16(math.MaxUint16)
+	assert.True(t, c16.IsSaturated())
+	assert.Equal(t, c16.Estimate(), c16.Increment())
+	assert.False(t, Count16(0).IsSaturated())
+}
+
+func TestCount_MaxCount(t *testing.T) {
+	counters := []Counter{new(Count4), new(Count8), new(Count16)}
+	tables := []uint{n4[upper4-1], n8[upper8-1], lookup16().n[upper16-1]}
+	for i, c := range counters {
+		assert.Equal(t, tables[i], c.MaxCount())
+	}
+
+	assert.Equal(t, uint(MaxCount8), Count8(math.MaxUint8).Estimate())
+	assert.Equal(t, uint(MaxCount16), Count16(math.MaxUint16).Estimate())
+}
+
+func TestCount24(t *testing.T) {
+	var c Count24
+	assert.Equal(t, 4, int(unsafe.Sizeof(c)))
+	assert.Equal(t, uint64(MaxCount24), c.MaxCount())
+	assert.Equal(t, uint64(MaxCount24), Count24(mask24).Estimate())
+	assert.Equal(t, float32(1), c.IncrementProbability())
+	assert.Equal(t, float32(0), Count24(mask24).IncrementProbability())
+
+	// Nearly exact for small counts
+	for i := 1; i <= 1000; i++ {
+		c.Increment()
+	}
+	assert.InDelta(t, 1000, float64(c.Estimate()), 5)
+
+	for i := 0; i < 1000000; i++ {
+		c.Increment()
+	}
+	assert.InDelta(t, 1001000, float64(c.Estimate()), 1001000*0.005)
+
+	// Saturates without wrapping, and the upper bits are ignored
+	c = mask24
+	assert.True(t, c.IsSaturated())
+	assert.Equal(t, uint64(MaxCount24), c.Increment())
+	assert.Equal(t, uint64(1), Count24(upper24+1).Estimate())
+}
+
+func TestCount_EstimateFloat(t *testing.T) {
+	for i := 0; i < upper8; i++ {
+		c := Count8(i)
+		assert.InDelta(t, c.Estimate(), c.EstimateFloat(), 1)
+	}
+
+	for i := 0; i < upper16; i += 7 {
+		c := Count16(i)
+		assert.InDelta(t, c.Estimate(), c.EstimateFloat(), 1)
+	}
+
+	assert.InDelta(t, Count4(15).Estimate(), Count4(15).EstimateFloat(), 1)
+	assert.InDelta(t, 1, Count16(1).EstimateFloat(), 1e-9)
+	assert.Greater(t, Count16(1000).EstimateFloat(), float64(Count16(1000).Estimate()))
+}
+
+func TestNewCounterForError(t *testing.T) {
+	tests := []struct {
+		max    uint
+		err    float64
+		expect Counter
+	}{
+		{1000, 0.5, new(Count4)},
+		{1000, 0.2, new(Count8)},
+		{1e5, 0.1, new(Count8)},
+		{1e6, 0.1, new(Count16)},
+		{1e5, 0.01, new(Count16)},
+	}
+
+	for _, tc := range tests {
+		c, err := NewCounterForError(tc.max, tc.err)
+		assert.NoError(t, err)
+		assert.IsType(t, tc.expect, c)
+	}
+
+	_, err := NewCounterForError(1e5, 0.001)
+	assert.Error(t, err)
+	_, err = NewCounterForError(MaxCount16+1, 0.1)
+	assert.Error(t, err)
+}
+
+func TestCount_IncrementProbability(t *testing.T) {
+	assert.Equal(t, float32(1), Count8(0).IncrementProbability())
+	assert.Equal(t, float32(1), Count16(0).IncrementProbability())
+	assert.Equal(t, float32(1), Count4(0).IncrementProbability())
+	assert.Equal(t, float32(0), Count8(math.MaxUint8).IncrementProbability())
+	assert.Equal(t, float32(0), Count16(math.MaxUint16).IncrementProbability())
+	assert.Equal(t, float32(0), Count4(15).IncrementProbability())
+
+	// Probability decreases as the counter fills up
+	for i := 1; i < math.MaxUint8; i++ {
+		assert.Less(t, Count8(i).IncrementProbability(), Count8(i-1).IncrementProbability())
+	}
+}
+
 func TestCount16x4_SizeOf(t *testing.T) {
 	var c Count16x4
 	assert.Equal(t, 8, int(unsafe.Sizeof(c)))
@@ -140,3 +280,383 @@ func TestCount16x4_First10(t *testing.T) {
 		assert.Equal(t, i, int(c.EstimateAt(0)))
 	}
 }
+
+func TestCount4_Overflow(t *testing.T) {
+	var c Count4
+	for i := 0; i < 1e5; i++ {
+		c.Increment()
+	}
+
+	assert.Equal(t, Count4(15), c)
+	assert.Equal(t, n4[15], c.Estimate())
+}
+
+func TestCount4x16_SizeOf(t *testing.T) {
+	var c Count4x16
+	assert.Equal(t, 8, int(unsafe.Sizeof(c)))
+}
+
+func TestCount4x16_IncrementAt(t *testing.T) {
+	var c Count4x16
+	for i := 0; i < 16; i++ {
+		assert.True(t, c.IncrementAt(i))
+		assert.Equal(t, uint(1), c.EstimateAt(i))
+	}
+
+	for i := 0; i < 1000; i++ {
+		c.IncrementAt(3)
+	}
+
+	assert.Greater(t, c.EstimateAt(3), uint(1))
+	assert.Equal(t, uint(1), c.EstimateAt(2))
+	assert.Equal(t, uint(1), c.EstimateAt(4))
+	assert.Len(t, c.Reset(), 16)
+	assert.Equal(t, [16]uint{}, c.Estimate())
+}
+
+func TestCount4x16_Bounds(t *testing.T) {
+	var c Count4x16
+	assert.False(t, c.IncrementAt(16))
+	assert.False(t, c.IncrementAt(-1))
+	assert.Equal(t, uint(0), c.EstimateAt(16))
+}
+
+func TestCount16x4_IncrementAtN(t *testing.T) {
+	var c Count16x4
+	assert.False(t, c.incrementAtN(0, 0, 0))
+	assert.True(t, c.incrementAtN(0, 10, 0))
+	assert.Equal(t, uint(10), c.EstimateAt(0))
+
+	for i := 0; i < 1000; i++ {
+		c.incrementAtN(1, 1000, roll32())
+	}
+
+	assert.InDelta(t, 1e6, c.EstimateAt(1), 1e6*0.02)
+	assert.Equal(t, uint(0), c.EstimateAt(2))
+
+	// Exported version validates the index and returns the new estimate
+	assert.Zero(t, c.IncrementAtN(-1, 10))
+	assert.Zero(t, c.IncrementAtN(4, 10))
+	estimate := c.IncrementAtN(2, 100000)
+	assert.Equal(t, c.EstimateAt(2), estimate)
+	assert.InDelta(t, 100000, estimate, 100000*0.01)
+	assert.Equal(t, uint(0), c.EstimateAt(3))
+}
+
+func TestAdvance16_Saturates(t *testing.T) {
+	assert.Equal(t, uint16(math.MaxUint16), advance16(0, math.MaxUint32, 0))
+	assert.Equal(t, uint16(math.MaxUint16), advance16(math.MaxUint16, 1, 0))
+}
+
+func TestEncode16(t *testing.T) {
+	assert.Equal(t, uint16(0), encode16(0))
+	assert.Equal(t, uint16(0), encode16(-1))
+	assert.Equal(t, uint16(0), encode16(math.NaN()))
+	assert.Equal(t, uint16(math.MaxUint16), encode16(math.Inf(1)))
+	for _, state := range []uint16{1, 2, 10, 1000, 30000, 60000} {
+		assert.Equal(t, state, encode16(n(float64(state), scale16)))
+	}
+}
+
+func TestRand(t *testing.T) {
+	var sum float64
+	for i := 0; i < 1e5; i++ {
+		v := Rand()
+		assert.GreaterOrEqual(t, v, float32(0))
+		assert.Less(t, v, float32(1))
+		sum += float64(v)
+	}
+
+	assert.InDelta(t, 0.5, sum/1e5, 0.01)
+}
+
+func TestCount16x4_Raw(t *testing.T) {
+	var c Count16x4
+	c.StoreRaw(0x0004_0003_0002_0001)
+
+	raw, estimate := c.Load()
+	assert.Equal(t, uint64(0x0004_0003_0002_0001), raw)
+	assert.Equal(t, [4]uint{1, 2, 3, 4}, estimate)
+
+	// Swap only succeeds with the current value
+	assert.False(t, c.CompareAndSwapRaw(0, 1))
+	assert.True(t, c.CompareAndSwapRaw(raw, raw+1))
+	assert.Equal(t, uint(2), c.EstimateAt(0))
+}
+
+func TestCount16x4_ResetAt(t *testing.T) {
+	var c Count16x4
+	c.StoreRaw(0x0004_0003_0002_0001)
+
+	assert.Equal(t, uint(3), c.ResetAt(2))
+	assert.Equal(t, [4]uint{1, 2, 0, 4}, c.Estimate())
+	assert.Equal(t, uint(0), c.ResetAt(2))
+	assert.Equal(t, uint(0), c.ResetAt(4))
+	assert.Equal(t, uint(0), c.ResetAt(-1))
+	assert.Equal(t, [4]uint{1, 2, 0, 4}, c.Estimate())
+}
+
+func TestCount16x4_Saturation(t *testing.T) {
+	var c Count16x4
+	c.StoreRaw(0x0000_FFFF_0000_FFFE)
+	assert.False(t, c.IsSaturatedAt(0))
+	assert.True(t, c.IsSaturatedAt(2))
+	assert.False(t, c.IsSaturatedAt(4))
+	assert.False(t, c.IsSaturatedAt(-1))
+
+	// A saturated lane must not wrap around, even with a roll that always succeeds
+	assert.False(t, c.incrementAt(lookup16(), 2, -1))
+	assert.True(t, c.incrementAt(lookup16(), 0, -1))
+	assert.False(t, c.incrementAt(lookup16(), 0, -1))
+	assert.Equal(t, [4]uint{MaxCount16, 0, MaxCount16, 0}, c.Estimate())
+
+	// Incrementing far past the range must keep the estimate at its maximum
+	for i := 0; i < 1000; i++ {
+		c.IncrementAt(0)
+		c.IncrementAtN(2, MaxCount16)
+	}
+	assert.True(t, c.IsSaturatedAt(0))
+	assert.True(t, c.IsSaturatedAt(2))
+	assert.Equal(t, [4]uint{MaxCount16, 0, MaxCount16, 0}, c.Estimate())
+
+	var c16 Count16 = math.MaxUint16
+	assert.Equal(t, uint(MaxCount16), c16.Increment())
+	assert.True(t, c16.IsSaturated())
+}
+
+func TestCount16x4_IncrementAtForced(t *testing.T) {
+	var c Count16x4
+	for i := 1; i <= 1000; i++ {
+		c.IncrementAtForced(1)
+		assert.Equal(t, uint16(i), c.state(1))
+	}
+	assert.Equal(t, uint(n(1000, scale16)), c.EstimateAt(1))
+	assert.Equal(t, [4]uint{0, c.EstimateAt(1), 0, 0}, c.Estimate())
+
+	// Bounds are checked and a saturated lane does not wrap
+	assert.Equal(t, uint(0), c.IncrementAtForced(4))
+	assert.Equal(t, uint(0), c.IncrementAtForced(-1))
+	c.StoreRaw(0xFFFF)
+	assert.Equal(t, uint(MaxCount16), c.IncrementAtForced(0))
+	assert.True(t, c.IsSaturatedAt(0))
+}
+
+func TestCount16x4Peak(t *testing.T) {
+	var c Count16x4Peak
+	assert.Equal(t, 16, int(unsafe.Sizeof(c)))
+
+	for i := 0; i < 100; i++ {
+		c.IncrementAt(1)
+	}
+	c.IncrementAtN(2, 500)
+	live := c.Estimate()
+	assert.InDelta(t, 100, live[1], 10)
+	assert.InDelta(t, 500, live[2], 10)
+	assert.Equal(t, live, c.Peak())
+
+	// The peak survives a reset of the counters
+	assert.Equal(t, live[1], c.ResetAt(1))
+	assert.Equal(t, uint(0), c.EstimateAt(1))
+	assert.Equal(t, live[1], c.PeakAt(1))
+	c.IncrementAt(1)
+	assert.Equal(t, uint(1), c.EstimateAt(1))
+	assert.Equal(t, live[1], c.PeakAt(1))
+
+	assert.Equal(t, [4]uint{0, 1, live[2], 0}, c.Reset())
+	assert.Equal(t, [4]uint{0, 0, 0, 0}, c.Estimate())
+	assert.Equal(t, live, c.ResetPeak())
+	assert.Equal(t, [4]uint{0, 0, 0, 0}, c.Peak())
+
+	// Out of bounds lanes are ignored
+	assert.False(t, c.IncrementAt(4))
+	assert.Equal(t, uint(0), c.IncrementAtN(-1, 10))
+	assert.Equal(t, uint(0), c.PeakAt(4))
+}
+
+func TestCount_Sum(t *testing.T) {
+	var c16 Count16x4
+	c16.StoreRaw(0x0004_0003_0002_0001)
+	assert.Equal(t, uint(10), c16.Sum())
+
+	var c4 Count4x16
+	assert.Equal(t, uint(0), c4.Sum())
+	c4.IncrementAt(0)
+	c4.IncrementAt(15)
+	assert.Equal(t, uint(2), c4.Sum())
+}
+
+func TestCountDeltas(t *testing.T) {
+	d8 := Count8Deltas()
+	assert.Equal(t, Count8(10).IncrementProbability(), d8[10])
+	assert.Equal(t, float32(0), d8[255])
+	d8[10] = 0
+	assert.NotZero(t, Count8(10).IncrementProbability())
+
+	d16 := Count16Deltas()
+	assert.Len(t, d16, 65536)
+	assert.Equal(t, Count16(1000).IncrementProbability(), d16[1000])
+	d16[1000] = 0
+	assert.NotZero(t, Count16(1000).IncrementProbability())
+}
+
+func TestMergeCount16(t *testing.T) {
+	var a, b Count16
+	for i := 0; i < 50000; i++ {
+		a.Increment()
+		b.Increment()
+	}
+
+	merged := MergeCount16(a, b)
+	assert.InDelta(t, 100000, float64(merged.Estimate()), 100000*0.05)
+	assert.InDelta(t, a.EstimateFloat()+b.EstimateFloat(), merged.EstimateFloat(), 100000/scale16)
+
+	// Merging with an empty counter preserves the state, and saturates at the maximum
+	assert.Equal(t, a, MergeCount16(a, 0))
+	assert.Equal(t, Count16(0), MergeCount16(0, 0))
+	assert.Equal(t, Count16(math.MaxUint16), MergeCount16(math.MaxUint16, a))
+}
+
+func TestAutoCount(t *testing.T) {
+	var c AutoCount
+	var _ Counter = &c
+	assert.Equal(t, uint(MaxCount16), c.MaxCount())
+	assert.Equal(t, uint(0), c.Estimate())
+
+	// Drive the 8-bit counter to saturation, the estimate is preserved on promotion
+	for !c.IsPromoted() {
+		c.Increment()
+	}
+	assert.Equal(t, Count8(math.MaxUint8), c.small)
+	assert.InDelta(t, float64(MaxCount8), float64(c.Estimate()), MaxCount8*0.01)
+
+	// Keeps counting past the 8-bit limit
+	for i := 0; i < 200000; i++ {
+		c.Increment()
+	}
+	assert.Greater(t, c.Estimate(), uint(MaxCount8))
+}
+
+func TestHybridCount(t *testing.T) {
+	var c HybridCount
+	var _ Counter = &c
+	assert.Equal(t, 2, int(unsafe.Sizeof(c)))
+	assert.Greater(t, c.MaxCount(), uint(MaxCount16/2))
+
+	// Exact below the cutoff
+	for i := 1; i <= HybridCutoff; i++ {
+		assert.Equal(t, uint(i), c.Increment())
+	}
+	assert.False(t, c.IsExact())
+
+	// Approximate and continuous above the cutoff
+	for i := 0; i < 100000; i++ {
+		c.Increment()
+	}
+	assert.InDelta(t, 100000+HybridCutoff, float64(c.Estimate()), 100000*0.05)
+
+	// Saturates at the maximum count
+	c = math.MaxUint16
+	assert.Equal(t, c.MaxCount(), c.Increment())
+}
+
+func TestCount16x4_EqualSet(t *testing.T) {
+	var a, b Count16x4
+	assert.True(t, a.Equal(&b))
+
+	a.StoreRaw(0x0001000200030004)
+	assert.False(t, a.Equal(&b))
+
+	b.Set(&a)
+	assert.True(t, a.Equal(&b))
+	assert.Equal(t, a.Estimate(), b.Estimate())
+
+	// The copy is independent of the original
+	a.Reset()
+	assert.False(t, a.Equal(&b))
+}
+
+func TestIncrementRaw(t *testing.T) {
+	var c8 Count8
+	estimate, raw8 := c8.IncrementRaw()
+	assert.Equal(t, uint(1), estimate)
+	assert.Equal(t, uint8(1), raw8)
+
+	var c16 Count16
+	for i := 0; i < 1000; i++ {
+		estimate, raw16 := c16.IncrementRaw()
+		assert.Equal(t, uint16(c16), raw16)
+		assert.Equal(t, c16.Estimate(), estimate)
+	}
+}
+
+func TestEstimateWithStdDev(t *testing.T) {
+	estimate, stddev := Count16(0).EstimateWithStdDev()
+	assert.Zero(t, estimate)
+	assert.Zero(t, stddev)
+
+	estimate, stddev = Count8(1).EstimateWithStdDev()
+	assert.Equal(t, uint(1), estimate)
+	assert.InDelta(t, 0, stddev, 0.01)
+
+	// The relative deviation converges to 1/sqrt(2a) for large counts
+	estimate, stddev = Count8(200).EstimateWithStdDev()
+	assert.InDelta(t, 1/math.Sqrt(2*scale8), stddev/float64(estimate), 0.01)
+	estimate, stddev = Count16(40000).EstimateWithStdDev()
+	assert.InDelta(t, 1/math.Sqrt(2*scale16), stddev/float64(estimate), 0.001)
+}
+
+func TestEstimateWithStdDev_Empirical(t *testing.T) {
+	const runs, count = 2000, 1000
+	var sum, sumSq float64
+	for i := 0; i < runs; i++ {
+		var c Count8
+		for j := 0; j < count; j++ {
+			c.Increment()
+		}
+
+		x := c.EstimateFloat()
+		sum, sumSq = sum+x, sumSq+x*x
+	}
+
+	// Compare the empirical deviation with the analytic one at the true count
+	mean := sum / runs
+	expect := deviation(count, scale8)
+	assert.InDelta(t, expect, math.Sqrt(sumSq/runs-mean*mean), expect*0.15)
+}
+
+func TestByEstimate(t *testing.T) {
+	counters := ByEstimate{Count16(5000), Count16(0), Count16(1), Count16(65535), Count16(2)}
+	sort.Sort(counters)
+	assert.True(t, sort.IsSorted(counters))
+	assert.Equal(t, ByEstimate{0, 1, 2, 5000, 65535}, counters)
+}
+
+func TestCount8x8(t *testing.T) {
+	var c Count8x8
+	for i := 0; i < 8; i++ {
+		for j := 0; j < 1000; j++ {
+			c.IncrementAt(i)
+		}
+	}
+
+	var sum uint
+	for i, v := range c.Estimate() {
+		assert.Equal(t, v, c.EstimateAt(i))
+		sum += v
+	}
+
+	assert.InDelta(t, 1000, float64(sum)/8, 300)
+
+	assert.False(t, c.IncrementAt(8))
+	assert.Equal(t, uint(0), c.EstimateAt(-1))
+	assert.Equal(t, c.Estimate(), c.Reset())
+	assert.Equal(t, [8]uint{}, c.Estimate())
+}
+
+func TestCount8x8_Saturation(t *testing.T) {
+	var c Count8x8
+	c.v.Store(math.MaxUint8 << 16)
+	assert.False(t, c.incrementAt(2, -1))
+	assert.Equal(t, uint(MaxCount8), c.EstimateAt(2))
+	assert.Equal(t, uint(0), c.EstimateAt(3))
+}
@@ -0,0 +1,167 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package approx
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/zeebo/xxh3"
+)
+
+// CountSketch is a sketch data structure for estimating the frequency of items in a
+// turnstile stream, where items can be both added and removed. Unlike CountMin, each row
+// uses a sign hash so that collisions cancel out on average, and the estimate is the
+// median of the signed row estimates, making it unbiased.
+type CountSketch struct {
+	depth int           // number of hash functions
+	width int           // number of counters per hash function
+	pos   [][]Count16x4 // positive contributions
+	neg   [][]Count16x4 // negative contributions
+}
+
+// NewCountSketch creates a new CountSketch with default depth and width
+func NewCountSketch() (*CountSketch, error) {
+	return NewCountSketchWithSize(5, 1024)
+}
+
+// NewCountSketchWithSize creates a new CountSketch with the given depth and width. An odd
+// depth is recommended so that the median is well defined. The width is rounded up to
+// the next multiple of 4.
+func NewCountSketchWithSize(depth, width uint) (*CountSketch, error) {
+	switch {
+	case depth == 0:
+		return nil, errors.New("sketch: depth should be greater than zero")
+	case depth > MaxDepth:
+		return nil, errors.New("sketch: depth should be at most MaxDepth (128)")
+	case width > MaxWidth:
+		return nil, errors.New("sketch: width should be at most MaxWidth")
+	}
+
+	width = (width + Stripe - 1) / Stripe * Stripe
+	pos := make([][]Count16x4, depth)
+	neg := make([][]Count16x4, depth)
+	for i := range pos {
+		pos[i] = make([]Count16x4, width/Stripe)
+		neg[i] = make([]Count16x4, width/Stripe)
+	}
+
+	return &CountSketch{
+		depth: int(depth),
+		width: int(width),
+		pos:   pos,
+		neg:   neg,
+	}, nil
+}
+
+// sign returns whether the contribution of the hash to a row should be negated. The
+// lower 32 bits select the column, so the sign is derived from a mix of the full hash.
+func sign(hx uint64) bool {
+	return (hx*0x9E3779B97F4A7C15)>>63 == 1
+}
+
+// Update increments the counter for the given item
+func (c *CountSketch) Update(item []byte) {
+	c.AddHash(xxh3.Hash(item), 1)
+}
+
+// UpdateString increments the counter for the given item
+func (c *CountSketch) UpdateString(item string) {
+	c.AddHash(xxh3.HashString(item), 1)
+}
+
+// UpdateHash increments the counter for the given item
+func (c *CountSketch) UpdateHash(hash uint64) {
+	c.AddHash(hash, 1)
+}
+
+// Remove decrements the counter for the given item
+func (c *CountSketch) Remove(item []byte) {
+	c.AddHash(xxh3.Hash(item), -1)
+}
+
+// RemoveString decrements the counter for the given item
+func (c *CountSketch) RemoveString(item string) {
+	c.AddHash(xxh3.HashString(item), -1)
+}
+
+// RemoveHash decrements the counter for the given item
+func (c *CountSketch) RemoveHash(hash uint64) {
+	c.AddHash(hash, -1)
+}
+
+// AddHash adds the given signed delta to the counter for the given item
+func (c *CountSketch) AddHash(hash uint64, delta int) {
+	if delta == 0 {
+		return
+	}
+
+	w := uint64(c.width)
+	r := roll32() // Keep same random value for all counters
+	for i := 0; i < c.depth; i++ {
+		hx := index(hash, i, false)
+		idx := reduce(hx, w)
+
+		// Positive contributions go to one side and negative to the other, so that
+		// both can be accumulated with the unsigned counters.
+		side := c.pos[i]
+		if (delta < 0) != sign(hx) {
+			side = c.neg[i]
+		}
+
+		n := uint(delta)
+		if delta < 0 {
+			n = uint(-delta)
+		}
+
+		at, lane := cell(side, idx)
+		at.incrementAtN(lane, n, r)
+	}
+}
+
+// Count returns the estimated frequency of the given item
+func (c *CountSketch) Count(item []byte) int {
+	return c.CountHash(xxh3.Hash(item))
+}
+
+// CountString returns the estimated frequency of the given item
+func (c *CountSketch) CountString(item string) int {
+	return c.CountHash(xxh3.HashString(item))
+}
+
+// CountHash returns the estimated frequency of the given item, as the median of the
+// signed estimates of every row. The estimate can be negative if removals dominate.
+func (c *CountSketch) CountHash(hash uint64) int {
+	var buffer [MaxDepth]int
+	rows := buffer[:c.depth]
+	w := uint64(c.width)
+	for i := range rows {
+		hx := index(hash, i, false)
+		idx := reduce(hx, w)
+		p, lane := cell(c.pos[i], idx)
+		n, _ := cell(c.neg[i], idx)
+		v := int(p.EstimateAt(lane)) - int(n.EstimateAt(lane))
+		if sign(hx) {
+			v = -v
+		}
+		rows[i] = v
+	}
+
+	slices.Sort(rows)
+	mid := len(rows) / 2
+	if len(rows)%2 == 0 {
+		return (rows[mid-1] + rows[mid]) / 2
+	}
+	return rows[mid]
+}
+
+// Reset resets the sketch to its initial state
+func (c *CountSketch) Reset() {
+	for d := range c.pos {
+		for j := range c.pos[d] {
+			c.pos[d][j].Reset()
+			c.neg[d][j].Reset()
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package approx
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountSketch(t *testing.T) {
+	cs, err := NewCountSketch()
+	assert.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		for j := 0; j < i; j++ {
+			cs.UpdateString(strconv.Itoa(i))
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		assert.InDelta(t, i, cs.CountString(strconv.Itoa(i)), 10)
+	}
+
+	// Removals cancel out additions
+	for j := 0; j < 50; j++ {
+		cs.RemoveString("99")
+		cs.Remove([]byte("98"))
+	}
+	assert.InDelta(t, 49, cs.CountString("99"), 10)
+	assert.InDelta(t, 48, cs.Count([]byte("98")), 10)
+
+	// Estimates can be negative
+	for j := 0; j < 20; j++ {
+		cs.RemoveString("negative")
+	}
+	assert.InDelta(t, -20, cs.CountString("negative"), 5)
+
+	cs.Reset()
+	assert.Equal(t, 0, cs.CountString("50"))
+}
+
+func TestCountSketch_AddHash(t *testing.T) {
+	cs, err := NewCountSketchWithSize(4, 256)
+	assert.NoError(t, err)
+
+	cs.AddHash(1234, 1000)
+	cs.AddHash(1234, -400)
+	cs.AddHash(1234, 0)
+	assert.InDelta(t, 600, cs.CountHash(1234), 20)
+	cs.UpdateHash(1234)
+	cs.RemoveHash(1234)
+	cs.Update([]byte("x"))
+	assert.InDelta(t, 600, cs.CountHash(1234), 20)
+}
+
+func TestCountSketch_Validation(t *testing.T) {
+	_, err := NewCountSketchWithSize(0, 1024)
+	assert.Error(t, err)
+	_, err = NewCountSketchWithSize(129, 1024)
+	assert.Error(t, err)
+	_, err = NewCountSketchWithSize(3, MaxWidth+1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,138 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package approx
+
+import "sort"
+
+// TopHeap is a bounded min-heap which retains the k elements with the highest count.
+// The count of each element is provided by an extractor function, which allows the
+// heap to be used with arbitrary element types. It is not safe for concurrent use.
+type TopHeap[T any] struct {
+	items []T
+	count func(T) uint64
+}
+
+// NewTopHeap creates a new bounded heap retaining at most k elements, ordered by the
+// count returned by the extractor function.
+func NewTopHeap[T any](k int, count func(T) uint64) *TopHeap[T] {
+	return &TopHeap[T]{
+		items: make([]T, 0, max(k, 0)),
+		count: count,
+	}
+}
+
+// Len returns the number of elements in the heap.
+func (h *TopHeap[T]) Len() int {
+	return len(h.items)
+}
+
+// Cap returns the maximum number of elements the heap can retain.
+func (h *TopHeap[T]) Cap() int {
+	return cap(h.items)
+}
+
+// At returns the element at index i of the heap.
+func (h *TopHeap[T]) At(i int) T {
+	return h.items[i]
+}
+
+// Min returns the element with the lowest count, if any.
+func (h *TopHeap[T]) Min() (x T, ok bool) {
+	if len(h.items) == 0 {
+		return x, false
+	}
+
+	return h.items[0], true
+}
+
+// Push adds an element to the heap. If the heap is full, the element replaces the
+// minimum element only if its count is higher. It returns whether the element was added.
+func (h *TopHeap[T]) Push(x T) bool {
+	switch {
+	case cap(h.items) == 0:
+		return false
+	case len(h.items) == cap(h.items) && h.count(x) <= h.count(h.items[0]):
+		return false
+	case len(h.items) == cap(h.items):
+		h.items[0] = x
+		h.down(0, len(h.items))
+		return true
+	default:
+		h.items = append(h.items, x)
+		h.up(len(h.items) - 1)
+		return true
+	}
+}
+
+// Pop removes and returns the element with the lowest count, if any.
+func (h *TopHeap[T]) Pop() (x T, ok bool) {
+	if len(h.items) == 0 {
+		return x, false
+	}
+
+	n := len(h.items) - 1
+	h.items[0], h.items[n] = h.items[n], h.items[0]
+	h.down(0, n)
+
+	x = h.items[n]
+	h.items = h.items[:n]
+	return x, true
+}
+
+// Update replaces the element at index i and restores the heap ordering.
+func (h *TopHeap[T]) Update(i int, x T) {
+	h.items[i] = x
+	if !h.down(i, len(h.items)) {
+		h.up(i)
+	}
+}
+
+// Values returns a copy of the elements, ordered from lowest to highest count.
+func (h *TopHeap[T]) Values() []T {
+	out := make([]T, len(h.items))
+	copy(out, h.items)
+	sort.Slice(out, func(i, j int) bool {
+		return h.count(out[i]) < h.count(out[j])
+	})
+	return out
+}
+
+// Reset removes all of the elements from the heap, retaining its capacity.
+func (h *TopHeap[T]) Reset() {
+	clear(h.items)
+	h.items = h.items[:0]
+}
+
+func (h *TopHeap[T]) up(j int) {
+	for {
+		i := (j - 1) / 2 // parent
+		if i == j || !(h.count(h.items[j]) < h.count(h.items[i])) {
+			break
+		}
+
+		h.items[i], h.items[j] = h.items[j], h.items[i]
+		j = i
+	}
+}
+
+func (h *TopHeap[T]) down(at, n int) bool {
+	i := at
+	for {
+		j1, ok := child(i, n)
+		if !ok {
+			break
+		}
+		j := j1 // left child
+		if j2 := j1 + 1; j2 < n && h.count(h.items[j2]) < h.count(h.items[j1]) {
+			j = j2 // = 2*i + 2  // right child
+		}
+		if h.count(h.items[i]) < h.count(h.items[j]) {
+			break
+		}
+
+		h.items[i], h.items[j] = h.items[j], h.items[i]
+		i = j
+	}
+	return i > at
+}
@@ -0,0 +1,62 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package approx
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type pair struct {
+	key   int
+	count uint64
+}
+
+func TestTopHeap(t *testing.T) {
+	h := NewTopHeap(5, func(v pair) uint64 { return v.count })
+	assert.Equal(t, 5, h.Cap())
+
+	for _, i := range rand.Perm(100) {
+		h.Push(pair{key: i, count: uint64(i)})
+	}
+
+	assert.Equal(t, 5, h.Len())
+	assert.Equal(t, []pair{{95, 95}, {96, 96}, {97, 97}, {98, 98}, {99, 99}}, h.Values())
+	assert.False(t, h.Push(pair{key: 1, count: 1}))
+
+	min, ok := h.Min()
+	assert.True(t, ok)
+	assert.Equal(t, 95, min.key)
+
+	// Update the minimum to become the maximum
+	h.Update(0, pair{key: 95, count: 1000})
+	min, _ = h.Min()
+	assert.Equal(t, 96, min.key)
+
+	// Pop all of the elements in order
+	for _, expect := range []int{96, 97, 98, 99, 95} {
+		v, ok := h.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, expect, v.key)
+	}
+
+	_, ok = h.Pop()
+	assert.False(t, ok)
+	_, ok = h.Min()
+	assert.False(t, ok)
+}
+
+func TestTopHeap_Empty(t *testing.T) {
+	h := NewTopHeap(0, func(v pair) uint64 { return v.count })
+	assert.False(t, h.Push(pair{key: 1, count: 1}))
+	assert.Equal(t, 0, h.Len())
+
+	h = NewTopHeap(3, func(v pair) uint64 { return v.count })
+	h.Push(pair{key: 1, count: 1})
+	h.Reset()
+	assert.Equal(t, 0, h.Len())
+	assert.Equal(t, 3, h.Cap())
+}
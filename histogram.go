@@ -0,0 +1,67 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package approx
+
+// Histogram is a fixed-size approximate histogram, backed by packed 16-bit counters.
+// Each bucket counts up to ~2 billion observations and is safe for concurrent use.
+type Histogram struct {
+	size   int         // number of buckets
+	counts []Count16x4 // packed counters, 4 buckets per word
+}
+
+// NewHistogram creates a new histogram with the given number of buckets.
+func NewHistogram(buckets int) *Histogram {
+	buckets = max(buckets, 0)
+	return &Histogram{
+		size:   buckets,
+		counts: make([]Count16x4, (buckets+Stripe-1)/Stripe),
+	}
+}
+
+// Len returns the number of buckets in the histogram.
+func (h *Histogram) Len() int {
+	return h.size
+}
+
+// Observe increments the counter of the given bucket. It returns true if the bucket
+// estimate was updated, and false if it was not or the bucket is out of range.
+func (h *Histogram) Observe(bucket int) bool {
+	if bucket < 0 || bucket >= h.size {
+		return false
+	}
+
+	at, lane := cell(h.counts, bucket)
+	return at.IncrementAt(lane)
+}
+
+// Estimate returns the estimated count of the given bucket, or zero if the bucket
+// is out of range.
+func (h *Histogram) Estimate(bucket int) uint {
+	if bucket < 0 || bucket >= h.size {
+		return 0
+	}
+
+	at, lane := cell(h.counts, bucket)
+	return at.EstimateAt(lane)
+}
+
+// Buckets returns the estimated counts of all of the buckets.
+func (h *Histogram) Buckets() []uint {
+	out := make([]uint, 0, h.size)
+	for i := range h.counts {
+		for _, v := range h.counts[i].Estimate() {
+			if len(out) < h.size {
+				out = append(out, v)
+			}
+		}
+	}
+	return out
+}
+
+// Reset sets all of the buckets to zero.
+func (h *Histogram) Reset() {
+	for i := range h.counts {
+		h.counts[i].Reset()
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package approx
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram(6)
+	assert.Equal(t, 6, h.Len())
+	assert.Len(t, h.counts, 2)
+
+	for i := 0; i < 6; i++ {
+		for j := 0; j <= i; j++ {
+			h.Observe(i)
+		}
+	}
+
+	buckets := h.Buckets()
+	assert.Len(t, buckets, 6)
+	for i, v := range buckets {
+		assert.InDelta(t, i+1, v, 1)
+		assert.Equal(t, v, h.Estimate(i))
+	}
+
+	h.Reset()
+	assert.Equal(t, []uint{0, 0, 0, 0, 0, 0}, h.Buckets())
+}
+
+func TestHistogram_Bounds(t *testing.T) {
+	h := NewHistogram(3)
+	assert.False(t, h.Observe(-1))
+	assert.False(t, h.Observe(3))
+	assert.Equal(t, uint(0), h.Estimate(3))
+
+	h = NewHistogram(-1)
+	assert.Equal(t, 0, h.Len())
+	assert.Empty(t, h.Buckets())
+}
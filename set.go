@@ -0,0 +1,48 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package approx
+
+import "github.com/zeebo/xxh3"
+
+// ApproxSet is a presence gate built on a Count-Min Sketch, which answers whether an
+// item has been seen more than a given number of times. This is useful for gating
+// behaviour after N occurrences, such as rate limiting.
+type ApproxSet struct {
+	cms *CountMin
+}
+
+// NewApproxSet creates a new set backed by a Count-Min Sketch with default size.
+func NewApproxSet() (*ApproxSet, error) {
+	cms, err := NewCountMin()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ApproxSet{cms: cms}, nil
+}
+
+// SeenMoreThan records an occurrence of the item and returns whether the item has now
+// been seen more than k times.
+func (s *ApproxSet) SeenMoreThan(item []byte, k uint) bool {
+	return s.SeenMoreThanHash(xxh3.Hash(item), k)
+}
+
+// SeenMoreThanString records an occurrence of the item and returns whether the item
+// has now been seen more than k times.
+func (s *ApproxSet) SeenMoreThanString(item string, k uint) bool {
+	return s.SeenMoreThanHash(xxh3.HashString(item), k)
+}
+
+// SeenMoreThanHash records an occurrence of the item and returns whether the item has
+// now been seen more than k times. Since the sketch may overestimate, the gate may
+// open slightly early, and since the counters are approximate it may rarely open an
+// occurrence late.
+func (s *ApproxSet) SeenMoreThanHash(hash uint64, k uint) bool {
+	return s.cms.UpdateAndCountHash(hash) > k
+}
+
+// Reset clears all of the recorded occurrences.
+func (s *ApproxSet) Reset() {
+	s.cms.Reset()
+}
@@ -0,0 +1,27 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package approx
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApproxSet(t *testing.T) {
+	set, err := NewApproxSet()
+	assert.NoError(t, err)
+
+	for i := 1; i <= 3; i++ {
+		assert.False(t, set.SeenMoreThanString("foo", 3))
+	}
+
+	// The approximate counters may rarely skip an increment, so allow for one
+	assert.True(t, set.SeenMoreThanString("foo", 2))
+	assert.True(t, set.SeenMoreThan([]byte("foo"), 3))
+	assert.False(t, set.SeenMoreThan([]byte("bar"), 3))
+
+	set.Reset()
+	assert.False(t, set.SeenMoreThanString("foo", 3))
+}
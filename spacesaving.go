@@ -0,0 +1,142 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package approx
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/axiomhq/hyperloglog"
+	"github.com/zeebo/xxh3"
+)
+
+// SpaceSaving implements the Space-Saving algorithm to calculate the top-k frequent
+// elements in a stream. It monitors exactly k counters and, when a new element arrives
+// and all of them are taken, replaces the element with the minimum count, inheriting
+// its count as the maximum overestimation. Unlike TopK, it does not rely on a Count-Min
+// Sketch and gives tighter guarantees for the same memory: any element whose frequency
+// is above total/k is guaranteed to be monitored.
+type SpaceSaving struct {
+	mu    sync.Mutex
+	heap  minheap
+	index map[uint64]int // position of the elements in the heap, by hash
+	hll   *hyperloglog.Sketch
+}
+
+// NewSpaceSaving creates a new structure to track the top-k elements in a stream using
+// the Space-Saving algorithm. The k parameter specifies the number of monitored counters.
+func NewSpaceSaving(k uint) (*SpaceSaving, error) {
+	if k == 0 {
+		return nil, errors.New("topk: k should be greater than zero")
+	}
+
+	return &SpaceSaving{
+		heap:  make(minheap, 0, k),
+		index: make(map[uint64]int, k),
+		hll:   hyperloglog.New(),
+	}, nil
+}
+
+// Update adds the value to the monitored counters
+func (s *SpaceSaving) Update(value string) {
+	hash := xxh3.HashString(value)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hll.InsertHash(hash)
+
+	// If the element is already monitored, simply increment it
+	if i := s.find(hash, value); i >= 0 {
+		s.heap.Update(i, s.heap[i].Count+1, s.index)
+		return
+	}
+
+	// Copy the string in case the caller reuses the buffer
+	clone := string(append([]byte(nil), value...))
+	if len(s.heap) < cap(s.heap) {
+		s.heap.Push(TopValue{Value: clone, hash: hash, Count: 1}, s.index)
+		return
+	}
+
+	// Replace the element with the minimum count, which becomes the error bound
+	lowest := s.heap[0].Count
+	if i, ok := s.index[s.heap[0].hash]; ok && i == 0 {
+		delete(s.index, s.heap[0].hash)
+	}
+
+	s.heap[0] = TopValue{Value: clone, hash: hash, over: lowest}
+	s.index[hash] = 0
+	s.heap.Update(0, lowest+1, s.index)
+}
+
+// find returns the index of the element in the heap, or -1 if it is not monitored. The
+// hash index is verified against the value, and values whose hashes collide share an
+// entry of the index, so they are found with a linear scan instead.
+func (s *SpaceSaving) find(hash uint64, value string) int {
+	i, ok := s.index[hash]
+	switch {
+	case !ok:
+		return -1
+	case s.heap[i].Value == value:
+		return i
+	}
+
+	for i := range s.heap {
+		if elem := &s.heap[i]; hash == elem.hash && value == elem.Value {
+			return i
+		}
+	}
+	return -1
+}
+
+// Values returns the monitored elements from lowest to highest frequency.
+func (s *SpaceSaving) Values() []TopValue {
+	s.mu.Lock()
+	output := make(minheap, 0, cap(s.heap))
+	s.heap.Clone(&output)
+	s.mu.Unlock()
+
+	// Sort the elements before returning
+	sort.Sort(&output)
+	return output
+}
+
+// Count returns the estimated count of the value, or zero if it is not monitored. The
+// estimate never underestimates the true count of a monitored value.
+func (s *SpaceSaving) Count(value string) uint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := s.find(xxh3.HashString(value), value); i >= 0 {
+		return uint(s.heap[i].Count)
+	}
+	return 0
+}
+
+// Error returns the maximum overestimation of the count of the value, or zero if it is
+// not monitored. The true count of the value is at least its count minus the error.
+func (s *SpaceSaving) Error(value string) uint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := s.find(xxh3.HashString(value), value); i >= 0 {
+		return uint(s.heap[i].over)
+	}
+	return 0
+}
+
+// Cardinality returns the estimated cardinality of the stream.
+func (s *SpaceSaving) Cardinality() uint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return uint(s.hll.Estimate())
+}
+
+// Reset clears the monitored counters and the cardinality estimator.
+func (s *SpaceSaving) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.heap.Reset()
+	clear(s.index)
+	s.hll = hyperloglog.New()
+}
@@ -0,0 +1,96 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package approx
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zeebo/xxh3"
+)
+
+func TestSpaceSaving(t *testing.T) {
+	ss, err := NewSpaceSaving(10)
+	assert.NoError(t, err)
+
+	// Interleave two heavy hitters with many distinct values
+	for i := 0; i < 2000; i++ {
+		ss.Update(strconv.Itoa(i))
+		if i%2 == 0 {
+			ss.Update("a")
+		}
+		if i%4 == 0 {
+			ss.Update("b")
+		}
+	}
+
+	// Elements above total/k are guaranteed to be monitored
+	values := ss.Values()
+	assert.Len(t, values, 10)
+	assert.Equal(t, "a", values[9].Value)
+	assert.Equal(t, "b", values[8].Value)
+
+	// Counts never underestimate, and the error bounds the overestimation
+	assert.GreaterOrEqual(t, ss.Count("a"), uint(1000))
+	assert.LessOrEqual(t, ss.Count("a")-ss.Error("a"), uint(1000))
+	assert.GreaterOrEqual(t, ss.Count("b"), uint(500))
+	assert.LessOrEqual(t, ss.Count("b")-ss.Error("b"), uint(500))
+	assert.Zero(t, ss.Count("missing"))
+	assert.Zero(t, ss.Error("missing"))
+
+	// The monitored counts always sum up to the number of updates
+	var sum uint
+	for _, v := range values {
+		sum += uint(v.Count)
+	}
+	assert.Equal(t, uint(3500), sum)
+	assert.InDelta(t, 2002, ss.Cardinality(), 50)
+
+	ss.Reset()
+	assert.Empty(t, ss.Values())
+	assert.Zero(t, ss.Cardinality())
+}
+
+func TestSpaceSaving_Exact(t *testing.T) {
+	ss, err := NewSpaceSaving(100)
+	assert.NoError(t, err)
+
+	// While there is enough room, counts are exact
+	for _, v := range deck(50) {
+		ss.Update(v)
+	}
+
+	for i := 1; i < 50; i++ {
+		assert.Equal(t, uint(i), ss.Count(strconv.Itoa(i)))
+		assert.Zero(t, ss.Error(strconv.Itoa(i)))
+	}
+}
+
+func TestSpaceSaving_Index(t *testing.T) {
+	ss, err := NewSpaceSaving(10)
+	assert.NoError(t, err)
+
+	for _, v := range deck(50) {
+		ss.Update(v)
+	}
+
+	// Every monitored element is indexed at its position in the heap
+	assert.Len(t, ss.index, 10)
+	for i, v := range ss.heap {
+		assert.Equal(t, i, ss.index[v.hash])
+	}
+
+	// A value colliding with a monitored hash is verified and monitored separately
+	ss.Reset()
+	ss.heap.Push(TopValue{Value: "x", hash: xxh3.HashString("y"), Count: 5}, ss.index)
+	ss.Update("y")
+	assert.Equal(t, uint(1), ss.Count("y"))
+	assert.Equal(t, uint32(5), ss.heap[ss.find(xxh3.HashString("y"), "x")].Count)
+}
+
+func TestSpaceSaving_Validation(t *testing.T) {
+	_, err := NewSpaceSaving(0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,39 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package approx
+
+// Stats represents the introspection metrics of a sketch, suitable for exporting to a
+// metrics system. Fields which do not apply to a structure are left as zero.
+type Stats struct {
+	Total       uint    `json:"total"`       // Total number of observations
+	Distinct    uint    `json:"distinct"`    // Estimated number of distinct observations
+	Utilization float64 `json:"utilization"` // Fraction of saturated counters
+	Depth       int     `json:"depth"`       // Number of rows of the sketch
+	Width       int     `json:"width"`       // Number of columns of the sketch
+	Heads       int     `json:"heads"`       // Number of tracked top-k elements
+	Capacity    int     `json:"capacity"`    // Maximum number of tracked top-k elements
+}
+
+// Stats returns the introspection metrics of the sketch.
+func (c *CountMin) Stats() Stats {
+	return Stats{
+		Total:       c.Total(),
+		Utilization: c.Utilization(),
+		Depth:       c.depth,
+		Width:       c.width,
+	}
+}
+
+// Stats returns the introspection metrics of the top-k, including the ones of its
+// underlying Count-Min Sketch.
+func (t *TopK) Stats() Stats {
+	stats := t.cms.Stats()
+	stats.Distinct = t.Cardinality()
+
+	t.mu.RLock()
+	stats.Heads = len(t.heap)
+	stats.Capacity = cap(t.heap)
+	t.mu.RUnlock()
+	return stats
+}
@@ -0,0 +1,33 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package approx
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	topk, err := NewTopK(5)
+	assert.NoError(t, err)
+
+	for _, v := range deck(10) {
+		topk.Update(v)
+	}
+
+	stats := topk.Stats()
+	assert.Equal(t, uint(45), stats.Total)
+	assert.InDelta(t, 9, stats.Distinct, 1)
+	assert.Equal(t, 0.0, stats.Utilization)
+	assert.Equal(t, 4, stats.Depth)
+	assert.Equal(t, 1024, stats.Width)
+	assert.Equal(t, 5, stats.Heads)
+	assert.Equal(t, 5, stats.Capacity)
+
+	cms := topk.cms.Stats()
+	assert.Equal(t, uint(45), cms.Total)
+	assert.Equal(t, uint(0), cms.Distinct)
+	assert.Equal(t, 0, cms.Capacity)
+}
@@ -7,8 +7,11 @@
 package approx
 
 import (
+	"errors"
+	"math"
 	"sort"
 	"sync"
+	"unsafe"
 
 	"github.com/axiomhq/hyperloglog"
 	"github.com/zeebo/xxh3"
@@ -16,22 +19,35 @@ import (
 
 // TopValue represents a value and its associated count.
 type TopValue struct {
-	hash  uint64 `json:"-"`     // The hash of the value
-	Value string `json:"value"` // The associated value
-	Count uint32 `json:"count"` // The count of the value
+	hash     uint64 `json:"-"`                  // The hash of the value
+	seen     uint64 `json:"-"`                  // The sequence number when last seen
+	over     uint32 `json:"-"`                  // The maximum overestimation of the count
+	Value    string `json:"value"`              // The associated value
+	Count    uint32 `json:"count"`              // The count of the value
+	Distinct uint32 `json:"distinct,omitempty"` // The estimated number of distinct sub-values
 }
 
 // TopK uses a Count-Min Sketch to calculate the top-K frequent elements in a
 // stream.
 type TopK struct {
-	mu   sync.Mutex
-	heap minheap
-	cms  *CountMin
-	hll  *hyperloglog.Sketch
+	mu     sync.RWMutex // protects the heap
+	cmu    sync.Mutex   // protects the cardinality estimators
+	heap   minheap
+	index  map[uint64]int // position of the elements in the heap, by hash
+	cms    *CountMin
+	hll    *hyperloglog.Sketch
+	exact  map[uint64]struct{}            // exact set of hashes, while small
+	limit  int                            // maximum size of the exact set
+	decay  float64                        // half-life for recency-weighted eviction
+	seq    uint64                         // sequence number of the last insertion
+	verify bool                           // whether to compare values on hash match
+	subs   map[uint64]*hyperloglog.Sketch // distinct sub-values of each head, if enabled
 }
 
 // NewTopK creates a new structure to track the top-k elements in a stream. The k parameter
-// specifies the number of elements to track.
+// specifies the number of elements to track. When k is zero, no elements are tracked and
+// the structure acts as a lightweight frequency and cardinality tracker, see Count and
+// Cardinality.
 func NewTopK(k uint) (*TopK, error) {
 	cms, err := NewCountMin()
 	if err != nil {
@@ -39,95 +55,607 @@ func NewTopK(k uint) (*TopK, error) {
 	}
 
 	return &TopK{
-		cms:  cms,
-		heap: make(minheap, 0, k),
-		hll:  hyperloglog.New(),
+		cms:   cms,
+		heap:  make(minheap, 0, k),
+		index: make(map[uint64]int),
+		hll:   hyperloglog.New(),
 	}, nil
 }
 
+// NewTopKWithExactCardinality creates a new structure to track the top-k elements in a
+// stream, which counts the cardinality exactly until it exceeds the threshold and then
+// falls back to the HyperLogLog estimate. This trades some memory for precise counts
+// on small streams.
+func NewTopKWithExactCardinality(k, threshold uint) (*TopK, error) {
+	t, err := NewTopK(k)
+	if err != nil {
+		return nil, err
+	}
+
+	t.limit = int(threshold)
+	t.exact = make(map[uint64]struct{}, threshold)
+	return t, nil
+}
+
+// NewTopKWithDecay creates a new structure to track the top-k elements in a stream, where
+// the eviction favours recent elements. The score count * 2^(-age/halfLife) of an element
+// halves every halfLife insertions without an update, and the lowest score is evicted.
+func NewTopKWithDecay(k, halfLife uint) (*TopK, error) {
+	if halfLife == 0 {
+		return nil, errors.New("topk: half-life should be greater than zero")
+	}
+
+	t, err := NewTopK(k)
+	if err != nil {
+		return nil, err
+	}
+
+	t.decay = float64(halfLife)
+	return t, nil
+}
+
+// NewTopKNoCardinality creates a new structure to track the top-k elements in a stream,
+// without the HyperLogLog used to estimate the cardinality. This saves its memory and
+// the cost of updating it, but Cardinality always returns zero.
+func NewTopKNoCardinality(k uint) (*TopK, error) {
+	t, err := NewTopK(k)
+	if err != nil {
+		return nil, err
+	}
+
+	t.hll = nil
+	return t, nil
+}
+
+// NewTopKWithSubCardinality creates a new structure to track the top-k elements in a
+// stream, which also estimates the number of distinct sub-values associated with each
+// of the heads, for example the distinct visitors of each of the top URLs. Sub-values
+// are fed with UpdateSub and only counted while their value is one of the heads, at the
+// cost of a HyperLogLog per head. The estimates are reported in TopValue.Distinct.
+func NewTopKWithSubCardinality(k uint) (*TopK, error) {
+	t, err := NewTopK(k)
+	if err != nil {
+		return nil, err
+	}
+
+	t.subs = make(map[uint64]*hyperloglog.Sketch, k)
+	return t, nil
+}
+
+// NewTopKWithVerification creates a new structure to track the top-k elements in a stream,
+// which compares the values of the elements in addition to their hashes. Without it, two
+// distinct values with the same 64-bit hash are treated as the same element, which has a
+// probability of roughly n²/2⁶⁵ for n distinct values. Note that colliding values still
+// share their counts in the underlying Count-Min Sketch. Since colliding values can
+// both be tracked, the elements are found with a linear scan instead of the hash index.
+func NewTopKWithVerification(k uint) (*TopK, error) {
+	t, err := NewTopK(k)
+	if err != nil {
+		return nil, err
+	}
+
+	t.verify = true
+	t.index = nil
+	return t, nil
+}
+
 // Update adds the binary value to Count-Min Sketch and updates the top-k elements.
 func (t *TopK) Update(value string) {
+	t.UpdateEvict(value)
+}
+
+// UpdateBytes adds the binary value to Count-Min Sketch and updates the top-k elements.
+// The value is copied when inserted, so the caller is free to reuse the buffer. Binary
+// values are stored as-is in the Value string of the resulting TopValue.
+func (t *TopK) UpdateBytes(value []byte) {
+	t.update(xxh3.Hash(value), unsafe.String(unsafe.SliceData(value), len(value)))
+}
+
+// UpdateEvict adds the value to Count-Min Sketch and updates the top-k elements. If
+// the value displaced another element from the top-k, the evicted element is returned.
+func (t *TopK) UpdateEvict(value string) (evicted TopValue, ok bool) {
+	return t.update(xxh3.HashString(value), value)
+}
+
+// UpdateWithRank adds the value to Count-Min Sketch and updates the top-k elements. It
+// returns the rank of the value after the update, where 0 is the most frequent element
+// and -1 means that the value is not in the top-k, and whether the rank has changed.
+func (t *TopK) UpdateWithRank(value string) (rank int, changed bool) {
 	hash := xxh3.HashString(value)
-	if updated := t.cms.UpdateHash(hash); !updated {
+	count, updated := t.record(hash)
+
+	// Both ranks are read under the same lock, so they are consistent with each other
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prev := t.rank(hash, value)
+	if updated {
+		t.insert(value, hash, count)
+	}
+
+	rank = t.rank(hash, value)
+	return rank, rank != prev
+}
+
+// UpdateSub adds the value to Count-Min Sketch and updates the top-k elements, then
+// associates the sub-value with the value if it is one of the heads. This requires
+// the structure to be created with NewTopKWithSubCardinality, otherwise the sub-value
+// is ignored.
+func (t *TopK) UpdateSub(value, sub string) {
+	hash := xxh3.HashString(value)
+	t.update(hash, value)
+	if t.subs == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.find(hash, value) < 0 {
+		return // not a head
+	}
+
+	hll := t.subs[hash]
+	if hll == nil {
+		hll = hyperloglog.New()
+		t.subs[hash] = hll
+	}
+	hll.InsertHash(xxh3.HashString(sub))
+}
+
+// UpdateMany adds the values to Count-Min Sketch and updates the top-k elements. This
+// is equivalent to calling Update for each of the values, but the sketch is updated
+// first without holding any lock, then the cardinality and heap locks are acquired only
+// once to apply the whole batch. An empty batch is a no-op.
+func (t *TopK) UpdateMany(values []string) {
+	updates := make([]TopValue, 0, len(values))
+	observed := make([]uint64, 0, len(values))
+	for _, value := range values {
+		hash := xxh3.HashString(value)
+		count, updated := t.cms.updateAndCount(hash, roll32())
+		if updated || t.limit > 0 {
+			observed = append(observed, hash)
+		}
+		if updated {
+			updates = append(updates, TopValue{hash: hash, Value: value, Count: uint32(count)})
+		}
+	}
+
+	if len(observed) == 0 {
+		return // Estimates haven't changed, skip
+	}
+
+	// Add the elements to the cardinality estimators
+	t.cmu.Lock()
+	for _, hash := range observed {
+		t.observe(hash)
+	}
+	t.cmu.Unlock()
+
+	// Apply the updates to the top-k heap
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, v := range updates {
+		t.insert(v.Value, v.hash, v.Count)
+	}
+}
+
+// update adds the hash to Count-Min Sketch and updates the top-k elements.
+func (t *TopK) update(hash uint64, value string) (evicted TopValue, ok bool) {
+	count, updated := t.record(hash)
+	if !updated {
 		return // Estimate hasn't changed, skip
 	}
 
-	// Try to insert the value into the top-k heap
-	count := uint32(t.cms.CountHash(hash))
-	t.tryInsert(value, hash, count)
+	// Try to insert the value into the top-k heap, only if it would be admitted
+	if !t.admits(count) {
+		return
+	}
+
+	return t.tryInsert(value, hash, count)
+}
+
+// record adds the hash to Count-Min Sketch and returns its new estimate, along with
+// whether the estimate has changed. If so, or if the cardinality is counted exactly,
+// the hash is also added to the cardinality estimators. This doesn't touch the top-k
+// heap.
+func (t *TopK) record(hash uint64) (count uint32, updated bool) {
+	estimate, updated := t.cms.updateAndCount(hash, roll32())
+	if updated || t.limit > 0 {
+		t.cmu.Lock()
+		t.observe(hash)
+		t.cmu.Unlock()
+	}
+
+	return uint32(estimate), updated
+}
+
+// admits returns whether an element with the given count might be admitted into the
+// top-k. This only takes the read lock, so most updates avoid the exclusive lock. With
+// decay, the lowest score is only known after an O(k) scan under the exclusive lock, so
+// every element is admitted here and rejected by insert instead.
+func (t *TopK) admits(count uint32) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	switch {
+	case cap(t.heap) == 0:
+		return false
+	case t.decay == 0 && len(t.heap) == cap(t.heap) && count <= t.heap[0].Count:
+		return false // tracked elements have at least this count, so nothing to update
+	default:
+		return true
+	}
 }
 
 // tryInsert adds the data to the top-k heap. If the data is already an element,
 // the frequency is updated. If the heap already has k elements, the element
-// with the minimum frequency is removed.
-func (t *TopK) tryInsert(value string, hash uint64, count uint32) {
+// with the minimum frequency is removed and returned.
+func (t *TopK) tryInsert(value string, hash uint64, count uint32) (evicted TopValue, ok bool) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
+	return t.insert(value, hash, count)
+}
 
-	// Add the element to HyperLogLog
-	t.hll.InsertHash(hash)
+// insert adds the data to the top-k heap, see tryInsert. This must be called while
+// holding the heap lock.
+func (t *TopK) insert(value string, hash uint64, count uint32) (evicted TopValue, ok bool) {
 	if cap(t.heap) == 0 {
 		return // no tracking
 	}
 
-	// If the element is not in the top-k, skip
-	if len(t.heap) == cap(t.heap) && count < t.heap[0].Count {
+	// If the element would not make it to the top-k, skip before scanning the heap. A
+	// tracked element already has at least the minimum count, so an equal count can't
+	// change anything. With decay, the lowest score might not belong to the minimum
+	// frequency element so we can't skip early.
+	if t.decay == 0 && len(t.heap) == cap(t.heap) && count <= t.heap[0].Count {
 		return
 	}
 
 	// If the element is already in the top-k, update it's count
-	for i := range t.heap {
-		if elem := &t.heap[i]; hash == elem.hash {
-			t.heap.Update(i, count)
+	t.seq++
+	if i := t.find(hash, value); i >= 0 {
+		t.heap[i].seen = t.seq
+		t.heap.Update(i, count, t.index)
+		return
+	}
+
+	// Remove minimum-frequency (or minimum-score) element.
+	switch {
+	case len(t.heap) < cap(t.heap):
+	case t.decay == 0:
+		evicted, ok = t.heap.Pop(t.index), true
+	default:
+		i := t.lowestScore()
+		if float64(count) <= t.score(t.heap[i]) {
 			return
 		}
+
+		evicted, ok = t.heap.Remove(i, t.index), true
 	}
 
-	// Remove minimum-frequency element.
-	if len(t.heap) == cap(t.heap) {
-		t.heap.Pop()
+	// Forget the sub-values of the evicted element, if any
+	if ok {
+		delete(t.subs, evicted.hash)
 	}
 
 	// Copy the string in case the caller reuses the buffer
 	clone := string(append([]byte(nil), value...))
 
 	// Add element to top-k and update min count
-	t.heap.Push(TopValue{Value: clone, hash: hash, Count: count})
+	t.heap.Push(TopValue{Value: clone, hash: hash, Count: count, seen: t.seq}, t.index)
+	return
+}
+
+// find returns the index of the element in the heap, or -1 if it is not tracked. It
+// uses the hash index when available, and otherwise scans the heap.
+func (t *TopK) find(hash uint64, value string) int {
+	if t.index != nil {
+		if i, ok := t.index[hash]; ok {
+			return i
+		}
+		return -1
+	}
+
+	for i := range t.heap {
+		if elem := &t.heap[i]; hash == elem.hash && (!t.verify || value == elem.Value) {
+			return i
+		}
+	}
+	return -1
+}
+
+// rank returns the position of the element in the top-k from highest to lowest
+// frequency, or -1 if it is not tracked. This is the same order as MostFrequent. This
+// must be called while holding the heap lock.
+func (t *TopK) rank(hash uint64, value string) (rank int) {
+	i := t.find(hash, value)
+	if i < 0 {
+		return -1
+	}
+
+	for j := range t.heap {
+		if t.heap.Less(i, j) {
+			rank++
+		}
+	}
+	return rank
+}
+
+// score returns the recency-weighted score of the element
+func (t *TopK) score(v TopValue) float64 {
+	return float64(v.Count) * math.Exp2(-float64(t.seq-v.seen)/t.decay)
+}
+
+// lowestScore returns the index of the element with the lowest recency-weighted score.
+// Since the scores keep decaying, they can't be ordered by the heap and this scans all of
+// the elements, which every insertion of an untracked element pays once the top-k is full.
+func (t *TopK) lowestScore() (idx int) {
+	lowest := math.Inf(1)
+	for i := range t.heap {
+		if s := t.score(t.heap[i]); s < lowest {
+			lowest, idx = s, i
+		}
+	}
+	return
+}
+
+// Remove evicts the value from the top-k and returns whether it was present. Since the
+// Count-Min Sketch cannot be decremented, the value retains its estimated count and a
+// subsequent Update of the same value may re-admit it into the top-k.
+func (t *TopK) Remove(value string) bool {
+	hash := xxh3.HashString(value)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if i := t.find(hash, value); i >= 0 {
+		t.heap.Remove(i, t.index)
+		delete(t.subs, hash)
+		return true
+	}
+	return false
+}
+
+// Decrement reduces the count of the value in the top-k by n, clamped at zero, if it
+// is currently tracked. Only the heap is adjusted since the Count-Min Sketch cannot be
+// decremented, so the next Update of the value restores its count to the sketch estimate.
+// This suits use-cases where the top-k is the source of truth and the sketch only gates
+// the admission. Elements decremented to zero are no longer returned by Values.
+func (t *TopK) Decrement(value string, n uint32) {
+	hash := xxh3.HashString(value)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if i := t.find(hash, value); i >= 0 {
+		t.heap.Update(i, t.heap[i].Count-min(t.heap[i].Count, n), t.index)
+	}
 }
 
 // Values returns the top-k elements from lowest to highest frequency.
 func (t *TopK) Values() []TopValue {
-	t.mu.Lock()
+	t.mu.RLock()
 	output := make(minheap, 0, cap(t.heap))
 	t.heap.Clone(&output)
-	t.mu.Unlock()
+	if t.subs != nil {
+		t.cmu.Lock() // estimating mutates the sketches, even under the read lock
+		t.distinct(output)
+		t.cmu.Unlock()
+	}
+	t.mu.RUnlock()
 
 	// Sort the elements before returning
 	sort.Sort(&output)
 	return output
 }
 
+// distinct fills in the estimated number of distinct sub-values of the elements, if
+// enabled. This must be called while holding the heap lock and the cardinality lock,
+// since estimating a HyperLogLog may merge its pending insertions.
+func (t *TopK) distinct(values []TopValue) {
+	for i := range values {
+		if hll := t.subs[values[i].hash]; hll != nil {
+			values[i].Distinct = uint32(hll.Estimate())
+		}
+	}
+}
+
+// MostFrequent returns up to n of the top-k elements from highest to lowest frequency.
+func (t *TopK) MostFrequent(n int) []TopValue {
+	output := t.Values()
+	n = min(max(n, 0), len(output))
+
+	// Reverse the elements, so the most frequent come first
+	for i, j := 0, len(output)-1; i < j; i, j = i+1, j-1 {
+		output[i], output[j] = output[j], output[i]
+	}
+	return output[:n]
+}
+
+// CountQuantile returns the count at the given quantile (between 0 and 1) among the
+// tracked top-k elements, using the nearest-rank method. Note that this only reflects
+// the heavy hitters tracked in the top-k and not the full distribution of the stream.
+func (t *TopK) CountQuantile(q float64) uint32 {
+	values := t.Values()
+	if len(values) == 0 {
+		return 0
+	}
+
+	q = min(max(q, 0), 1)
+	rank := int(math.Ceil(q*float64(len(values)))) - 1
+	return values[max(rank, 0)].Count
+}
+
+// Count returns the estimated frequency of the value, regardless of whether it is
+// currently tracked in the top-k. It is named after the Count of the other structures
+// of this package and simply delegates to Estimate.
+func (t *TopK) Count(value string) uint {
+	return t.Estimate(value)
+}
+
+// Estimate returns the Count-Min Sketch estimate of the frequency of the value, whether
+// or not it is one of the heads.
+func (t *TopK) Estimate(value string) uint {
+	return t.cms.CountString(value)
+}
+
+// Contains returns whether the value is currently tracked in the top-k.
+func (t *TopK) Contains(value string) bool {
+	hash := xxh3.HashString(value)
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.find(hash, value) >= 0
+}
+
 // Cardinality returns the estimated cardinality of the stream.
 func (t *TopK) Cardinality() uint {
+	t.cmu.Lock()
+	defer t.cmu.Unlock()
+
+	return t.cardinality()
+}
+
+// hllSize is the approximate size of a HyperLogLog once it switches to the dense
+// representation, with 2^14 registers of 4 bits each packed in pairs.
+const hllSize = 1 << 14 / 2
+
+// indexEntrySize is the approximate size of an entry of the hash index, with its 16
+// bytes of key and value and the overhead of the map buckets at their average load.
+const indexEntrySize = 24
+
+// SizeBytes returns the approximate memory footprint of the top-k in bytes. It includes
+// the Count-Min Sketch, the heap capacity along with the bytes of the tracked values,
+// the hash index and the dense size of the HyperLogLog and of the sub-cardinality
+// sketches of the heads, but not the exact cardinality set, if any. Sketches which are
+// still sparse are smaller, so this is an upper bound for them.
+func (t *TopK) SizeBytes() int {
+	t.mu.RLock()
+	size := int(unsafe.Sizeof(*t)) + t.cms.SizeBytes()
+	size += cap(t.heap) * int(unsafe.Sizeof(TopValue{}))
+	for _, v := range t.heap {
+		size += len(v.Value)
+	}
+	size += len(t.index) * indexEntrySize
+	size += len(t.subs) * hllSize
+	t.mu.RUnlock()
+
+	t.cmu.Lock()
+	if t.hll != nil {
+		size += hllSize
+	}
+	t.cmu.Unlock()
+	return size
+}
+
+// Total returns the total number of updates processed since the last reset.
+func (t *TopK) Total() uint {
+	return t.cms.Total()
+}
+
+// Snapshot returns the top-k elements from lowest to highest frequency, the estimated
+// cardinality of the stream and the total number of updates processed since the last
+// reset, all captured consistently with each other.
+func (t *TopK) Snapshot() ([]TopValue, uint, uint) {
 	t.mu.Lock()
-	defer t.mu.Unlock()
+	t.cmu.Lock()
+	output := make(minheap, 0, cap(t.heap))
+	n := t.cardinality()  // Estimate the cardinality
+	total := t.Total()    // Total number of updates
+	t.heap.Clone(&output) // Clone the top-k elements
+	t.distinct(output)    // Estimate distinct sub-values
+	t.cmu.Unlock()
+	t.mu.Unlock()
 
-	return uint(t.hll.Estimate())
+	// Sort the elements before returning
+	sort.Sort(&output)
+	return output, n, total
+}
+
+// observe adds the hash to the cardinality estimators
+func (t *TopK) observe(hash uint64) {
+	if t.hll == nil {
+		return // cardinality is not tracked
+	}
+
+	t.hll.InsertHash(hash)
+	if t.exact == nil {
+		return
+	}
+
+	// Stop tracking exactly once the set becomes too large
+	if t.exact[hash] = struct{}{}; len(t.exact) > t.limit {
+		t.exact = nil
+	}
+}
+
+// cardinality returns the exact cardinality if available, otherwise the estimate
+func (t *TopK) cardinality() uint {
+	switch {
+	case t.exact != nil:
+		return uint(len(t.exact))
+	case t.hll == nil:
+		return 0
+	default:
+		return uint(t.hll.Estimate())
+	}
 }
 
 // Reset restores the TopK to its original state. The function returns the top-k
 // elements and their counts as well as the estimated cardinality of the stream.
 func (t *TopK) Reset(k int) ([]TopValue, uint) {
 	t.mu.Lock()
-	output := make(minheap, 0, cap(t.heap))
-	n := t.hll.Estimate() // Estimate the cardinality
-	t.heap.Clone(&output) // Clone the top-k elements
-	t.resize(k)           // Resize the top-k heap
+	t.cmu.Lock()
+	output, n := t.drain(k)
+	t.cmu.Unlock()
 	t.mu.Unlock()
 
 	// Sort the elements before returning
 	sort.Sort(&output)
-	return output, uint(n)
+	return output, n
+}
+
+// Drain returns the top-k elements sorted from lowest to highest frequency along with
+// the estimated cardinality of the stream, and atomically resets the TopK to an empty
+// state with the same k. This suits windowed reporting, where each window starts empty.
+func (t *TopK) Drain() ([]TopValue, uint) {
+	t.mu.Lock()
+	t.cmu.Lock()
+	output, n := t.drain(cap(t.heap))
+	t.cmu.Unlock()
+	t.mu.Unlock()
+
+	// Sort the elements before returning
+	sort.Sort(&output)
+	return output, n
+}
+
+// drain clones the top-k elements and estimates the cardinality, then resizes the top-k
+// heap and resets the sketches. This must be called while holding both locks.
+func (t *TopK) drain(k int) (minheap, uint) {
+	output := make(minheap, 0, cap(t.heap))
+	n := t.cardinality()  // Estimate the cardinality
+	t.heap.Clone(&output) // Clone the top-k elements
+	t.distinct(output)    // Estimate distinct sub-values
+	t.resize(k)           // Resize the top-k heap
+	return output, n
+}
+
+// SetCapacity changes the number of elements tracked by the top-k, while keeping its
+// state. When growing, all of the current elements are kept. When shrinking, the
+// elements with the lowest counts are dropped until k remain. The Count-Min Sketch and
+// the cardinality estimate are left untouched.
+func (t *TopK) SetCapacity(k int) {
+	k = max(k, 0)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for len(t.heap) > k {
+		evicted := t.heap.Pop(t.index)
+		delete(t.subs, evicted.hash)
+	}
+
+	// Positions are preserved by the copy, so the index remains valid
+	heap := make(minheap, len(t.heap), k)
+	copy(heap, t.heap)
+	t.heap = heap
 }
 
 // reset resizes the top-k heap and resets the Count-Min Sketch and HyperLogLog.
@@ -141,7 +669,16 @@ func (t *TopK) resize(k int) {
 		t.heap.Reset()
 	}
 
+	// Positions and sub-values of the elements are no longer valid
+	clear(t.index)
+	clear(t.subs)
+
 	// Reset the Count-Min Sketch and HyperLogLog
 	t.cms.Reset()
-	t.hll = hyperloglog.New()
+	if t.hll != nil {
+		t.hll = hyperloglog.New()
+	}
+	if t.limit > 0 {
+		t.exact = make(map[uint64]struct{}, t.limit)
+	}
 }
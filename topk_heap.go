@@ -8,34 +8,66 @@ func (h *minheap) Reset() {
 	*h = (*h)[:0]
 }
 
-// Len, Less, Swap implement the sort.Interface.
-func (h *minheap) Len() int           { return len(*h) }
-func (h *minheap) Less(i, j int) bool { return (*h)[i].Count < (*h)[j].Count }
-func (h *minheap) Swap(i, j int)      { (*h)[i], (*h)[j] = (*h)[j], (*h)[i] }
+// Len, Less, Swap implement the sort.Interface. Elements with equal counts are ordered
+// by their value and hash, so that sorting is deterministic.
+func (h *minheap) Len() int      { return len(*h) }
+func (h *minheap) Swap(i, j int) { (*h)[i], (*h)[j] = (*h)[j], (*h)[i] }
+func (h *minheap) Less(i, j int) bool {
+	a, b := &(*h)[i], &(*h)[j]
+	switch {
+	case a.Count != b.Count:
+		return a.Count < b.Count
+	case a.Value != b.Value:
+		return a.Value < b.Value
+	default:
+		return a.hash < b.hash
+	}
+}
 
-// Push adds a new element to the heap.
-func (h *minheap) Push(x TopValue) {
+// Push adds a new element to the heap. If an index is provided, it is kept up to date
+// with the position of every element by its hash.
+func (h *minheap) Push(x TopValue, index map[uint64]int) {
 	*h = append(*h, x)
-	h.up(h.Len() - 1)
+	if index != nil {
+		index[x.hash] = h.Len() - 1
+	}
+	h.up(h.Len()-1, index)
 }
 
 // Pop returns the minimum element from the heap.
-func (h *minheap) Pop() TopValue {
+func (h *minheap) Pop(index map[uint64]int) TopValue {
+	n := h.Len() - 1
+	h.swap(0, n, index)
+	h.down(0, n, index)
+	return h.truncate(n, index)
+}
+
+// Remove removes and returns the element at index i from the heap.
+func (h *minheap) Remove(i int, index map[uint64]int) TopValue {
 	n := h.Len() - 1
-	h.Swap(0, n)
-	h.down(0, n)
+	if n != i {
+		h.swap(i, n, index)
+		if !h.down(i, n, index) {
+			h.up(i, index)
+		}
+	}
 
-	// Pop the last element
+	return h.truncate(n, index)
+}
+
+// truncate pops the last element, at index n, and removes it from the index.
+func (h *minheap) truncate(n int, index map[uint64]int) TopValue {
 	x := (*h)[n]
 	*h = (*h)[:n]
+	delete(index, x.hash)
 	return x
 }
 
 // Update updates the count of the element at index i.
-func (h minheap) Update(i int, count uint32) {
+func (h minheap) Update(i int, count uint32, index map[uint64]int) {
 	h[i].Count = count
-	if !h.down(i, len(h)) {
-		h.up(i)
+	if !h.down(i, len(h), index) {
+		h.up(i, index)
 	}
 }
 
@@ -48,23 +80,32 @@ func (h minheap) Clone(dst *minheap) {
 	}
 }
 
-func (h minheap) up(j int) {
+// swap swaps the elements at index i and j, updating their positions in the index
+func (h minheap) swap(i, j int, index map[uint64]int) {
+	h[i], h[j] = h[j], h[i]
+	if index != nil {
+		index[h[i].hash] = i
+		index[h[j].hash] = j
+	}
+}
+
+func (h minheap) up(j int, index map[uint64]int) {
 	for {
 		i := (j - 1) / 2 // parent
 		if i == j || !(h[j].Count < h[i].Count) {
 			break
 		}
 
-		h[i], h[j] = h[j], h[i]
+		h.swap(i, j, index)
 		j = i
 	}
 }
 
-func (h minheap) down(at, n int) bool {
+func (h minheap) down(at, n int, index map[uint64]int) bool {
 	i := at
 	for {
-		j1 := 2*i + 1
-		if j1 >= n || j1 < 0 { // j1 < 0 after int overflow
+		j1, ok := child(i, n)
+		if !ok {
 			break
 		}
 		j := j1 // left child
@@ -75,8 +116,18 @@ func (h minheap) down(at, n int) bool {
 			break
 		}
 
-		h[i], h[j] = h[j], h[i]
+		h.swap(i, j, index)
 		i = j
 	}
 	return i > at
 }
+
+// child returns the index of the left child of i in a heap of n elements, and whether
+// it exists. Unlike checking 2*i+1 against n, this can't overflow for any i and n, and
+// the right child at index+1 is at most n.
+func child(i, n int) (int, bool) {
+	if i < 0 || i >= n/2 {
+		return 0, false
+	}
+	return 2*i + 1, true
+}
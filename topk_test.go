@@ -6,11 +6,14 @@ package approx
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"math/rand"
 	"strconv"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/zeebo/xxh3"
 )
 
 /*
@@ -36,6 +39,138 @@ func BenchmarkTopK(b *testing.B) {
 	}
 }
 
+/*
+cpu: AMD EPYC (1 core)
+BenchmarkTopK_Mixed/reads=10%-8         	 2000000	        32.72 ns/op	      25 B/op	       0 allocs/op
+BenchmarkTopK_Mixed/reads=90%-8         	 2000000	        84.86 ns/op	     226 B/op	       0 allocs/op
+
+with sync.Mutex instead of sync.RWMutex:
+BenchmarkTopK_Mixed/reads=10%-8         	 2000000	        33.95 ns/op	      25 B/op	       0 allocs/op
+BenchmarkTopK_Mixed/reads=90%-8         	 2000000	        91.43 ns/op	     226 B/op	       0 allocs/op
+*/
+func BenchmarkTopK_Mixed(b *testing.B) {
+	const cardinality = 10000
+	data := deck(cardinality)
+
+	// The given percentage of operations are reads, half of them Values and half Contains
+	for _, reads := range []int{10, 90} {
+		topk, err := NewTopK(10)
+		assert.NoError(b, err)
+		for _, v := range data {
+			topk.Update(v)
+		}
+
+		b.Run(fmt.Sprintf("reads=%d%%", reads), func(b *testing.B) {
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					switch v := data[i%cardinality]; {
+					case i%100 < reads/2:
+						topk.Values()
+					case i%100 < reads:
+						topk.Contains(v)
+					default:
+						topk.Update(v)
+					}
+				}
+			})
+		})
+	}
+}
+
+/*
+cpu: AMD EPYC
+BenchmarkTopK_Skewed/k=10         	46961422	        25.87 ns/op	       0 B/op	       0 allocs/op
+BenchmarkTopK_Skewed/k=100        	45857181	        24.92 ns/op	       0 B/op	       0 allocs/op
+*/
+func BenchmarkTopK_Skewed(b *testing.B) {
+	const cardinality = 10000
+	zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, cardinality-1)
+	data := make([]string, 1<<16)
+	for i := range data {
+		data[i] = strconv.Itoa(int(zipf.Uint64()))
+	}
+
+	for _, k := range []uint{10, 100} {
+		topk, err := NewTopK(k)
+		assert.NoError(b, err)
+
+		b.Run(fmt.Sprintf("k=%d", k), func(b *testing.B) {
+			b.ReportAllocs()
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				topk.Update(data[n%len(data)])
+			}
+		})
+	}
+}
+
+/*
+cpu: AMD EPYC
+BenchmarkTopK_Large/k=1000/scan         	 2000000	       156.6 ns/op	       0 B/op	       0 allocs/op
+BenchmarkTopK_Large/k=1000/index        	 2000000	        66.56 ns/op	       0 B/op	       0 allocs/op
+BenchmarkTopK_Large/k=10000/scan        	 2000000	      1735 ns/op	       2 B/op	       0 allocs/op
+BenchmarkTopK_Large/k=10000/index       	 2000000	       115.9 ns/op	       2 B/op	       0 allocs/op
+BenchmarkTopK_Large/k=100000/scan       	 2000000	     26567 ns/op	       3 B/op	       0 allocs/op
+BenchmarkTopK_Large/k=100000/index      	 2000000	       221.9 ns/op	       8 B/op	       0 allocs/op
+*/
+func BenchmarkTopK_Large(b *testing.B) {
+	const cardinality = 1000000
+	zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.01, 1, cardinality-1)
+	data := make([]string, 1<<20)
+	for i := range data {
+		data[i] = strconv.Itoa(int(zipf.Uint64()))
+	}
+
+	// Verification scans the heap linearly, while the default uses the hash index
+	for _, k := range []uint{1000, 10000, 100000} {
+		for _, mode := range []string{"scan", "index"} {
+			topk, err := NewTopK(k)
+			assert.NoError(b, err)
+			if mode == "scan" {
+				topk, err = NewTopKWithVerification(k)
+				assert.NoError(b, err)
+			}
+
+			b.Run(fmt.Sprintf("k=%d/%s", k, mode), func(b *testing.B) {
+				b.ReportAllocs()
+				b.ResetTimer()
+				for n := 0; n < b.N; n++ {
+					topk.Update(data[n%len(data)])
+				}
+			})
+		}
+	}
+}
+
+/*
+cpu: AMD EPYC (1 core)
+BenchmarkTopK_Parallel/k=5              	 2000000	        30.39 ns/op	       0 B/op	       0 allocs/op
+BenchmarkTopK_Parallel/k=5-8            	 2000000	        27.93 ns/op	       0 B/op	       0 allocs/op
+BenchmarkTopK_Parallel/k=100            	 2000000	        29.30 ns/op	       0 B/op	       0 allocs/op
+BenchmarkTopK_Parallel/k=100-8          	 2000000	        26.67 ns/op	       0 B/op	       0 allocs/op
+*/
+func BenchmarkTopK_Parallel(b *testing.B) {
+	const cardinality = 10000
+	data := deck(cardinality)
+
+	for _, k := range []uint{5, 100} {
+		topk, err := NewTopK(k)
+		assert.NoError(b, err)
+
+		b.Run(fmt.Sprintf("k=%d", k), func(b *testing.B) {
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					topk.Update(data[i%cardinality])
+				}
+			})
+		})
+	}
+}
+
 func TestTopK(t *testing.T) {
 	const cardinality = 100
 	for _, k := range []uint{2, 5, 10, 15} {
@@ -103,6 +238,46 @@ func TestTopK_Reset(t *testing.T) {
 	}
 }
 
+func TestTopK_Drain(t *testing.T) {
+	topk, err := NewTopK(5)
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		for _, v := range deck(10) {
+			topk.Update(v)
+		}
+
+		values := topk.Values()
+		out, n := topk.Drain()
+		assert.Equal(t, values, out)
+		assert.InDelta(t, 10, int(n), 1)
+		assert.Empty(t, topk.Values())
+		assert.Zero(t, topk.Cardinality())
+		assert.Zero(t, topk.Total())
+		assert.Equal(t, 5, cap(topk.heap))
+	}
+}
+
+func TestTopK_Snapshot(t *testing.T) {
+	topk, err := NewTopK(5)
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		for _, v := range deck(10) {
+			topk.Update(v)
+		}
+
+		values, n, total := topk.Snapshot()
+		assert.Equal(t, topk.Values(), values)
+		assert.InDelta(t, 10, int(n), 1)
+		assert.Equal(t, uint(45), total)
+		assert.Equal(t, uint(45), topk.Total())
+
+		topk.Reset(5)
+		assert.Equal(t, uint(0), topk.Total())
+	}
+}
+
 func TestTopK_Race(t *testing.T) {
 	topk, err := NewTopK(5)
 	assert.NoError(t, err)
@@ -122,6 +297,266 @@ func TestTopK_Race(t *testing.T) {
 	}
 }
 
+func TestTopK_Contains(t *testing.T) {
+	topk, err := NewTopK(5)
+	assert.NoError(t, err)
+
+	for _, v := range spread(10) {
+		topk.Update(v)
+	}
+
+	assert.True(t, topk.Contains("9"))
+	assert.True(t, topk.Contains("5"))
+	assert.False(t, topk.Contains("1"))
+	assert.False(t, topk.Contains("foo"))
+}
+
+func TestTopK_Remove(t *testing.T) {
+	topk, err := NewTopK(5)
+	assert.NoError(t, err)
+
+	for _, v := range spread(10) {
+		topk.Update(v)
+	}
+
+	assert.True(t, topk.Remove("7"))
+	assert.False(t, topk.Remove("7"))
+	assert.False(t, topk.Remove("1"))
+	assert.False(t, topk.Contains("7"))
+
+	// Remaining elements are still tracked
+	var values []string
+	for _, v := range topk.Values() {
+		values = append(values, v.Value)
+	}
+	assert.ElementsMatch(t, []string{"5", "6", "8", "9"}, values)
+
+	// A subsequent update re-admits the value with its sketch count, once the update
+	// changes its estimate
+	for i := 0; i < 10; i++ {
+		topk.Update("7")
+	}
+	assert.True(t, topk.Contains("7"))
+}
+
+func TestTopK_UpdateEvict(t *testing.T) {
+	topk, err := NewTopK(2)
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, ok := topk.UpdateEvict("a")
+		assert.False(t, ok)
+	}
+
+	for i := 0; i < 5; i++ {
+		_, ok := topk.UpdateEvict("b")
+		assert.False(t, ok)
+	}
+
+	// The third value displaces the minimum once it reaches its count
+	var evicted []string
+	for i := 0; i < 5; i++ {
+		if v, ok := topk.UpdateEvict("c"); ok {
+			evicted = append(evicted, v.Value)
+		}
+	}
+
+	assert.Equal(t, []string{"a"}, evicted)
+	assert.False(t, topk.Contains("a"))
+}
+
+func TestTopK_MostFrequent(t *testing.T) {
+	topk, err := NewTopK(5)
+	assert.NoError(t, err)
+
+	for _, v := range deck(10) {
+		topk.Update(v)
+	}
+
+	top := topk.MostFrequent(3)
+	assert.Len(t, top, 3)
+	assert.GreaterOrEqual(t, top[0].Count, top[1].Count)
+	assert.GreaterOrEqual(t, top[1].Count, top[2].Count)
+
+	assert.Len(t, topk.MostFrequent(10), 5)
+	assert.Len(t, topk.MostFrequent(-1), 0)
+}
+
+func TestTopK_CountQuantile(t *testing.T) {
+	topk, err := NewTopK(4)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0), topk.CountQuantile(0.5))
+
+	for i, v := range []string{"a", "b", "c", "d"} {
+		topk.tryInsert(v, uint64(i), uint32(i+1)*10)
+	}
+
+	assert.Equal(t, uint32(10), topk.CountQuantile(0))
+	assert.Equal(t, uint32(10), topk.CountQuantile(0.25))
+	assert.Equal(t, uint32(20), topk.CountQuantile(0.5))
+	assert.Equal(t, uint32(30), topk.CountQuantile(0.7))
+	assert.Equal(t, uint32(40), topk.CountQuantile(1))
+	assert.Equal(t, uint32(40), topk.CountQuantile(2))
+}
+
+func TestTopK_ExactCardinality(t *testing.T) {
+	topk, err := NewTopKWithExactCardinality(5, 100)
+	assert.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		topk.Update(strconv.Itoa(i))
+		assert.Equal(t, uint(i+1), topk.Cardinality())
+	}
+
+	// Above the threshold, falls back to the estimate
+	for i := 100; i < 1000; i++ {
+		topk.Update(strconv.Itoa(i))
+	}
+	assert.Nil(t, topk.exact)
+	assert.InDelta(t, 1000, topk.Cardinality(), 50)
+
+	// Reset restores exact counting
+	_, n := topk.Reset(5)
+	assert.InDelta(t, 1000, n, 50)
+	topk.Update("foo")
+	assert.Equal(t, uint(1), topk.Cardinality())
+}
+
+func TestTopK_ExactCardinalityLarge(t *testing.T) {
+	const n = 50000
+	values := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		values = append(values, strconv.Itoa(i))
+	}
+
+	// Every value counts, even when its approximate counters don't move
+	single, err := NewTopKWithExactCardinality(5, 2*n)
+	assert.NoError(t, err)
+	for _, v := range values {
+		single.Update(v)
+	}
+	assert.Equal(t, uint(n), single.Cardinality())
+
+	batch, err := NewTopKWithExactCardinality(5, 2*n)
+	assert.NoError(t, err)
+	batch.UpdateMany(values)
+	assert.Equal(t, uint(n), batch.Cardinality())
+}
+
+func TestTopK_UpdateBytes(t *testing.T) {
+	topk, err := NewTopK(5)
+	assert.NoError(t, err)
+
+	// Reuse the same buffer for all of the updates
+	buffer := make([]byte, 0, 8)
+	for _, v := range deck(10) {
+		buffer = append(buffer[:0], v...)
+		topk.UpdateBytes(buffer)
+	}
+
+	values := topk.Values()
+	assert.Len(t, values, 5)
+	assert.ElementsMatch(t, []string{"5", "6", "7", "8", "9"}, valuesOf(values))
+	assert.True(t, topk.Contains("9"))
+
+	// Binary values are preserved
+	topk.Reset(5)
+	topk.UpdateBytes([]byte{0xff, 0x00, 0xfe})
+	topk.UpdateBytes(nil)
+	assert.True(t, topk.Contains(string([]byte{0xff, 0x00, 0xfe})))
+}
+
+func TestTopK_Decay(t *testing.T) {
+	_, err := NewTopKWithDecay(2, 0)
+	assert.Error(t, err)
+
+	topk, err := NewTopKWithDecay(2, 10)
+	assert.NoError(t, err)
+
+	// An element which was hot long ago
+	for i := 0; i < 100; i++ {
+		topk.Update("old")
+	}
+
+	// Currently trending elements eventually displace it
+	for i := 0; i < 100; i++ {
+		topk.Update("a")
+		topk.Update("b")
+	}
+
+	assert.False(t, topk.Contains("old"))
+	assert.True(t, topk.Contains("a"))
+	assert.True(t, topk.Contains("b"))
+
+	// Without decay, the hot element remains
+	topk, err = NewTopK(2)
+	assert.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		topk.Update("old")
+	}
+	for i := 0; i < 50; i++ {
+		topk.Update("a")
+		topk.Update("b")
+	}
+	assert.True(t, topk.Contains("old"))
+}
+
+func TestTopK_Verification(t *testing.T) {
+	for _, verify := range []bool{false, true} {
+		topk, err := NewTopK(5)
+		if verify {
+			topk, err = NewTopKWithVerification(5)
+		}
+		assert.NoError(t, err)
+
+		// Simulate a collision of two values with the same hash
+		topk.tryInsert("foo", 42, 10)
+		topk.tryInsert("bar", 42, 20)
+
+		assert.Equal(t, 0, topk.find(42, "foo"))
+		switch verify {
+		case true:
+			assert.Equal(t, []string{"foo", "bar"}, valuesOf(topk.Values()))
+		default:
+			assert.Equal(t, []string{"foo"}, valuesOf(topk.Values()))
+		}
+	}
+
+	topk, err := NewTopKWithVerification(5)
+	assert.NoError(t, err)
+	assert.True(t, topk.verify)
+}
+
+func TestTopK_Zero(t *testing.T) {
+	topk, err := NewTopK(0)
+	assert.NoError(t, err)
+
+	for _, v := range deck(10) {
+		topk.Update(v)
+	}
+
+	assert.Empty(t, topk.Values())
+	assert.Empty(t, topk.MostFrequent(5))
+	assert.False(t, topk.Contains("9"))
+	assert.InDelta(t, 9, int(topk.Cardinality()), 1)
+	assert.InDelta(t, 9, int(topk.Count("9")), 1)
+	assert.InDelta(t, 1, int(topk.Count("1")), 1)
+	assert.Equal(t, uint(0), topk.Count("foo"))
+	assert.Equal(t, uint(45), topk.Total())
+}
+
+func TestTopK_StableTies(t *testing.T) {
+	topk, err := NewTopK(10)
+	assert.NoError(t, err)
+
+	for _, i := range rand.Perm(10) {
+		topk.tryInsert(strconv.Itoa(i), uint64(i), 5)
+	}
+
+	assert.Equal(t, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"},
+		valuesOf(topk.Values()))
+}
+
 func TestTopK_JSON(t *testing.T) {
 	topk, err := NewTopK(5)
 	assert.NoError(t, err)
@@ -163,3 +598,337 @@ func deck(n int) []string {
 
 	return values
 }
+
+// spread returns a shuffled stream where the value i appears 10*i times, so that
+// the counts stay well apart despite the rounding of the sketch
+func spread(n int) []string {
+	values := make([]string, 0, 5*n*n)
+	for i := 0; i < n; i++ {
+		for j := 0; j < 10*i; j++ {
+			values = append(values, strconv.Itoa(i))
+		}
+	}
+
+	rand.Shuffle(len(values), func(i, j int) {
+		values[i], values[j] = values[j], values[i]
+	})
+	return values
+}
+
+func TestTopK_UpdateWithRank(t *testing.T) {
+	topk, err := NewTopK(3)
+	assert.NoError(t, err)
+
+	rank, changed := topk.UpdateWithRank("a")
+	assert.Equal(t, 0, rank)
+	assert.True(t, changed)
+
+	// Adding "b" moves it in front of "a", since ties are ordered by value
+	rank, changed = topk.UpdateWithRank("b")
+	assert.Equal(t, 0, rank)
+	assert.True(t, changed)
+
+	// Updating the leader again keeps its rank
+	for i := 0; i < 10; i++ {
+		rank, changed = topk.UpdateWithRank("b")
+		assert.Equal(t, 0, rank)
+		assert.False(t, changed)
+	}
+
+	// Ranks match the order of MostFrequent
+	for i, v := range topk.MostFrequent(3) {
+		assert.Equal(t, i, topk.rank(v.hash, v.Value))
+	}
+
+	// Untracked values have a rank of -1
+	assert.Equal(t, -1, topk.rank(0, "z"))
+}
+
+func TestTopK_UpdateWithRankConcurrent(t *testing.T) {
+	topk, err := NewTopK(10)
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value := strconv.Itoa(i)
+			for j := 0; j < 1000; j++ {
+				rank, _ := topk.UpdateWithRank(value)
+				assert.GreaterOrEqual(t, rank, 0)
+				assert.Less(t, rank, 4)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	assert.Len(t, topk.Values(), 4)
+}
+
+func TestTopK_NoCardinality(t *testing.T) {
+	topk, err := NewTopKNoCardinality(5)
+	assert.NoError(t, err)
+	assert.Nil(t, topk.hll)
+
+	for _, v := range deck(20) {
+		topk.Update(v)
+	}
+
+	assert.Len(t, topk.Values(), 5)
+	assert.Equal(t, uint(190), topk.Total())
+	assert.Zero(t, topk.Cardinality())
+
+	// Reset keeps the cardinality disabled
+	topk.Reset(5)
+	topk.Update("a")
+	assert.Nil(t, topk.hll)
+	assert.Zero(t, topk.Cardinality())
+}
+
+func TestTopK_SizeBytes(t *testing.T) {
+	topk, err := NewTopK(10)
+	assert.NoError(t, err)
+
+	empty := topk.SizeBytes()
+	assert.Greater(t, empty, topk.cms.SizeBytes()+hllSize)
+
+	topk.Update("hello")
+	assert.Equal(t, empty+len("hello")+indexEntrySize, topk.SizeBytes())
+
+	light, err := NewTopKNoCardinality(10)
+	assert.NoError(t, err)
+	assert.Equal(t, empty-hllSize, light.SizeBytes())
+	assert.Equal(t, 8192, hllSize)
+
+	// Each head with sub-values holds its own HyperLogLog
+	subs, err := NewTopKWithSubCardinality(10)
+	assert.NoError(t, err)
+	before := subs.SizeBytes()
+	subs.UpdateSub("a", "x")
+	subs.UpdateSub("b", "y")
+	assert.Equal(t, before+len("ab")+2*(hllSize+indexEntrySize), subs.SizeBytes())
+}
+
+func TestTopK_Estimate(t *testing.T) {
+	topk, err := NewTopK(2)
+	assert.NoError(t, err)
+
+	for _, v := range deck(10) {
+		topk.Update(v)
+	}
+
+	// Values outside of the heads can still be estimated
+	assert.False(t, topk.Contains("3"))
+	assert.GreaterOrEqual(t, topk.Estimate("3"), uint(3))
+	assert.Equal(t, topk.Count("9"), topk.Estimate("9"))
+	assert.Zero(t, topk.Estimate("missing"))
+}
+
+func TestTopK_Index(t *testing.T) {
+	plain, err := NewTopK(20)
+	assert.NoError(t, err)
+	decay, err := NewTopKWithDecay(20, 50)
+	assert.NoError(t, err)
+
+	for _, topk := range []*TopK{plain, decay} {
+		for i, v := range deck(100) {
+			topk.Update(v)
+			if i%50 == 0 {
+				topk.Remove(strconv.Itoa(i % 100))
+			}
+		}
+
+		// Every element is indexed at its position in the heap
+		assert.Len(t, topk.index, len(topk.heap))
+		for i, v := range topk.heap {
+			assert.Equal(t, i, topk.index[v.hash])
+		}
+
+		topk.Reset(10)
+		assert.Empty(t, topk.index)
+	}
+}
+
+func TestTopK_Decrement(t *testing.T) {
+	topk, err := NewTopK(3)
+	assert.NoError(t, err)
+	for i, v := range []string{"a", "b", "c"} {
+		topk.tryInsert(v, xxh3.HashString(v), uint32(i+1)*10)
+	}
+
+	// Decrementing re-orders the heap
+	topk.Decrement("c", 25)
+	assert.Equal(t, []string{"c", "a", "b"}, valuesOf(topk.Values()))
+	assert.Equal(t, uint32(5), topk.heap[0].Count)
+
+	// Counts are clamped at zero, and hidden from the values
+	topk.Decrement("c", 100)
+	assert.Equal(t, []string{"a", "b"}, valuesOf(topk.Values()))
+	topk.Decrement("missing", 1)
+	assert.Len(t, topk.heap, 3)
+}
+
+func TestTopK_SetCapacity(t *testing.T) {
+	topk, err := NewTopK(3)
+	assert.NoError(t, err)
+	for i, v := range []string{"a", "b", "c"} {
+		topk.tryInsert(v, xxh3.HashString(v), uint32(i+1)*10)
+	}
+	topk.cms.AddString("z", 7)
+
+	// Growing keeps all of the elements and admits new ones
+	topk.SetCapacity(5)
+	assert.Equal(t, 5, cap(topk.heap))
+	assert.Equal(t, []string{"a", "b", "c"}, valuesOf(topk.Values()))
+	topk.tryInsert("d", xxh3.HashString("d"), 5)
+	assert.Equal(t, []string{"d", "a", "b", "c"}, valuesOf(topk.Values()))
+
+	// Shrinking drops the lowest counts and keeps the index consistent
+	topk.SetCapacity(2)
+	assert.Equal(t, 2, cap(topk.heap))
+	assert.Equal(t, []string{"b", "c"}, valuesOf(topk.Values()))
+	assert.False(t, topk.Contains("a"))
+	assert.True(t, topk.Contains("b"))
+	assert.Len(t, topk.index, 2)
+	topk.Decrement("c", 25)
+	assert.Equal(t, []string{"c", "b"}, valuesOf(topk.Values()))
+
+	// The sketch is untouched
+	count := topk.Count("z")
+	assert.InDelta(t, 7, count, 1)
+	topk.SetCapacity(0)
+	assert.Empty(t, topk.Values())
+	assert.Equal(t, count, topk.Count("z"))
+}
+
+func TestTopK_SubCardinality(t *testing.T) {
+	topk, err := NewTopKWithSubCardinality(2)
+	assert.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		topk.UpdateSub("a", strconv.Itoa(i%10))
+		topk.UpdateSub("b", strconv.Itoa(i%50))
+		topk.UpdateSub("b", strconv.Itoa(i%50))
+	}
+
+	values := topk.Values()
+	assert.Equal(t, []string{"a", "b"}, valuesOf(values))
+	assert.InDelta(t, 10, values[0].Distinct, 1)
+	assert.InDelta(t, 50, values[1].Distinct, 2)
+
+	// Evicted heads forget their sub-values
+	for i := 0; i < 200; i++ {
+		topk.UpdateSub("c", "x")
+	}
+	assert.False(t, topk.Contains("a"))
+	assert.Len(t, topk.subs, 2)
+	assert.True(t, topk.Remove("c"))
+	assert.Len(t, topk.subs, 1)
+
+	// Without sub-cardinality, sub-values are ignored
+	plain, err := NewTopK(2)
+	assert.NoError(t, err)
+	plain.UpdateSub("a", "x")
+	assert.Zero(t, plain.Values()[0].Distinct)
+
+	topk.Reset(2)
+	assert.Empty(t, topk.subs)
+}
+
+func TestTopK_SubCardinalityConcurrent(t *testing.T) {
+	topk, err := NewTopKWithSubCardinality(2)
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				topk.UpdateSub("a", strconv.Itoa(j))
+				topk.Values()
+			}
+		}()
+	}
+
+	wg.Wait()
+	assert.InDelta(t, 1000, topk.Values()[0].Distinct, 50)
+}
+
+func TestTopK_UpdateMany(t *testing.T) {
+	topk, err := NewTopK(5)
+	assert.NoError(t, err)
+
+	topk.UpdateMany(nil)
+	assert.Zero(t, topk.Total())
+
+	topk.UpdateMany(deck(10))
+	assert.Equal(t, uint(45), topk.Total())
+	assert.InDelta(t, 10, int(topk.Cardinality()), 1)
+	assert.Len(t, topk.Values(), 5)
+	assert.Subset(t, valuesOf(topk.Values()), []string{"7", "8", "9"})
+
+	// Batches can be applied concurrently with readers
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				topk.UpdateMany(deck(10))
+				topk.Values()
+			}
+		}()
+	}
+
+	wg.Wait()
+	assert.Equal(t, uint(45*401), topk.Total())
+	assert.Subset(t, valuesOf(topk.Values()), []string{"7", "8", "9"})
+}
+
+func TestMinheap_Child(t *testing.T) {
+	for n := 0; n < 64; n++ {
+		for i := 0; i < n; i++ {
+			j, ok := child(i, n)
+			assert.Equal(t, 2*i+1 < n, ok)
+			if ok {
+				assert.Equal(t, 2*i+1, j)
+			}
+		}
+	}
+
+	// Indices past half of math.MaxInt would overflow with 2*i+1
+	for _, i := range []int{math.MaxInt / 2, math.MaxInt/2 + 1, math.MaxInt - 1} {
+		_, ok := child(i, math.MaxInt)
+		assert.False(t, ok)
+	}
+
+	j, ok := child(math.MaxInt/2-1, math.MaxInt)
+	assert.True(t, ok)
+	assert.Equal(t, math.MaxInt-2, j)
+}
+
+func TestMinheap_Order(t *testing.T) {
+	var h minheap
+	index := make(map[uint64]int)
+	for i, v := range rand.Perm(1000) {
+		h.Push(TopValue{hash: uint64(i), Count: uint32(v)}, index)
+	}
+
+	for i := 0; i < 100; i++ {
+		h.Remove(rand.Intn(h.Len()), index)
+	}
+
+	last := uint32(0)
+	for h.Len() > 0 {
+		for hash, at := range index {
+			assert.Equal(t, hash, h[at].hash)
+		}
+
+		v := h.Pop(index)
+		assert.GreaterOrEqual(t, v.Count, last)
+		last = v.Count
+	}
+	assert.Empty(t, index)
+}
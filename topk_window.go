@@ -0,0 +1,94 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package approx
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// WindowedTopK tracks the top-k elements over a sliding set of windows. Updates are
+// accumulated into the current window, and Rotate advances to the next one, dropping
+// the oldest window once the ring is full.
+type WindowedTopK struct {
+	mu   sync.RWMutex
+	k    uint
+	head int     // index of the current window
+	ring []*TopK // ring of windows
+}
+
+// NewWindowedTopK creates a new structure to track the top-k elements over the given
+// number of windows.
+func NewWindowedTopK(k uint, windows int) (*WindowedTopK, error) {
+	if windows < 1 {
+		return nil, errors.New("topk: number of windows should be at least 1")
+	}
+
+	ring := make([]*TopK, windows)
+	for i := range ring {
+		topk, err := NewTopK(k)
+		if err != nil {
+			return nil, err
+		}
+
+		ring[i] = topk
+	}
+
+	return &WindowedTopK{
+		k:    k,
+		ring: ring,
+	}, nil
+}
+
+// Update adds the value to the current window.
+func (w *WindowedTopK) Update(value string) {
+	w.mu.RLock()
+	w.ring[w.head].Update(value)
+	w.mu.RUnlock()
+}
+
+// Rotate advances to the next window, discarding the oldest one. It returns the top-k
+// elements of the window that was just completed, from lowest to highest frequency.
+func (w *WindowedTopK) Rotate() []TopValue {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	last := w.ring[w.head].Values()
+	w.head = (w.head + 1) % len(w.ring)
+	w.ring[w.head].Reset(int(w.k))
+	return last
+}
+
+// Values returns the top-k elements across all of the windows, from lowest to highest
+// frequency. The count of each element is the sum of its counts in every window.
+func (w *WindowedTopK) Values() []TopValue {
+	w.mu.RLock()
+	merged := make(map[uint64]TopValue, int(w.k)*len(w.ring))
+	for _, window := range w.ring {
+		for _, v := range window.Values() {
+			if prev, ok := merged[v.hash]; ok {
+				v.Count += prev.Count
+			}
+			merged[v.hash] = v
+		}
+	}
+	w.mu.RUnlock()
+
+	// Select the top-k elements out of the merged set
+	output := make(minheap, 0, w.k)
+	for _, v := range merged {
+		switch {
+		case len(output) < cap(output):
+			output.Push(v, nil)
+		case cap(output) > 0 && v.Count > output[0].Count:
+			output.Pop(nil)
+			output.Push(v, nil)
+		}
+	}
+
+	// Sort the elements before returning
+	sort.Sort(&output)
+	return output
+}
@@ -0,0 +1,51 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package approx
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindowedTopK(t *testing.T) {
+	topk, err := NewWindowedTopK(3, 2)
+	assert.NoError(t, err)
+
+	update := func(value string, n int) {
+		for i := 0; i < n; i++ {
+			topk.Update(value)
+		}
+	}
+
+	// First window
+	update("a", 10)
+	update("b", 5)
+	update("c", 1)
+	assert.Equal(t, []string{"c", "b", "a"}, valuesOf(topk.Values()))
+
+	// Second window, values are merged with the previous window
+	assert.Equal(t, []string{"c", "b", "a"}, valuesOf(topk.Rotate()))
+	update("b", 10)
+	update("d", 3)
+	assert.Equal(t, []string{"d", "a", "b"}, valuesOf(topk.Values()))
+
+	// Third window, the first window is dropped
+	topk.Rotate()
+	update("e", 1)
+	assert.Equal(t, []string{"e", "d", "b"}, valuesOf(topk.Values()))
+}
+
+func TestWindowedTopK_Invalid(t *testing.T) {
+	_, err := NewWindowedTopK(3, 0)
+	assert.Error(t, err)
+}
+
+func valuesOf(values []TopValue) []string {
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		out = append(out, v.Value)
+	}
+	return out
+}